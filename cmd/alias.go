@@ -0,0 +1,84 @@
+// Copyright © 2018 openSUSE opensuse-project@opensuse.org
+// Copyright © 2024 Patrick D'appollonio github@patrickdap.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// isRepoAlias reports whether the source argument is the name of a Helm
+// repository rather than a URL.
+func isRepoAlias(source string) bool {
+	return source != "" && !strings.ContainsAny(source, `/:\`)
+}
+
+// resolveRepoAlias returns the URL of the Helm repository named alias and the
+// path of the index file Helm caches for it.
+func resolveRepoAlias(alias string) (*url.URL, string, error) {
+	entry, err := helmRepository(alias, "")
+	if err != nil {
+		return nil, "", err
+	}
+
+	repoURL, err := url.Parse(entry.URL)
+	if err != nil {
+		return nil, "", fmt.Errorf("repository %q has an invalid URL %q: %w", alias, entry.URL, err)
+	}
+
+	return repoURL, filepath.Join(repositoryCachePath(), alias+"-index.yaml"), nil
+}
+
+// repositoryCachePath returns the folder Helm caches the repository index
+// files in: $HELM_REPOSITORY_CACHE, or the default of Helm.
+func repositoryCachePath() string {
+	if cache := os.Getenv("HELM_REPOSITORY_CACHE"); cache != "" {
+		return cache
+	}
+
+	return filepath.Join(helmCacheHome(), "repository")
+}
+
+// helmCacheHome returns the folder Helm keeps its cache in.
+func helmCacheHome() string {
+	if home := os.Getenv("HELM_CACHE_HOME"); home != "" {
+		return home
+	}
+
+	if home := os.Getenv("XDG_CACHE_HOME"); home != "" {
+		return filepath.Join(home, "helm")
+	}
+
+	home, _ := os.UserHomeDir()
+	switch runtime.GOOS {
+	case "darwin":
+		return filepath.Join(home, "Library", "Caches", "helm")
+	case "windows":
+		return filepath.Join(os.TempDir(), "helm")
+	default:
+		return filepath.Join(home, ".cache", "helm")
+	}
+}
+
+// isDir reports whether name is an existing directory.
+func isDir(name string) bool {
+	info, err := os.Stat(name)
+	return err == nil && info.IsDir()
+}
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_isRepoAlias(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   bool
+	}{
+		{"1", "stable", true},
+		{"2", "my-repo.v2", true},
+		{"3", "", false},
+		{"4", "https://charts.example.com", false},
+		{"5", "charts.example.com/stable", false},
+		{"6", "C:\\charts", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRepoAlias(tt.source); got != tt.want {
+				t.Errorf("isRepoAlias() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_resolveRepoAlias(t *testing.T) {
+	dir := t.TempDir()
+	repositories := filepath.Join(dir, "repositories.yaml")
+	if err := os.WriteFile(repositories, []byte(`apiVersion: ""
+repositories:
+- name: stable
+  url: https://charts.example.com/stable
+- name: broken
+  url: "%"
+`), 0o600); err != nil {
+		t.Errorf("writing repositories file: %s", err)
+	}
+	t.Setenv("HELM_REPOSITORY_CONFIG", repositories)
+	t.Setenv("HELM_REPOSITORY_CACHE", filepath.Join(dir, "cache"))
+
+	tests := []struct {
+		name      string
+		alias     string
+		wantURL   string
+		wantCache string
+		wantErr   bool
+	}{
+		{"1", "stable", "https://charts.example.com/stable", filepath.Join(dir, "cache", "stable-index.yaml"), false},
+		{"2", "unknown", "", "", true},
+		{"3", "broken", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotURL, gotCache, err := resolveRepoAlias(tt.alias)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("resolveRepoAlias() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if gotURL.String() != tt.wantURL || gotCache != tt.wantCache {
+				t.Errorf("resolveRepoAlias() = %s, %s, want %s, %s", gotURL, gotCache, tt.wantURL, tt.wantCache)
+			}
+		})
+	}
+}
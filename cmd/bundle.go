@@ -0,0 +1,144 @@
+// Copyright © 2018 openSUSE opensuse-project@opensuse.org
+// Copyright © 2024 Patrick D'appollonio github@patrickdap.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+
+	"github.com/konstructio/helm-mirror/service"
+	"github.com/spf13/cobra"
+)
+
+const exportDesc = `Package a mirrored folder into a single bundle file, to carry it across
+an air gap. Example:
+
+	helm mirror export /path/to/charts /path/to/mirror.tar.gz
+
+The bundle is a gzipped tar archive holding the charts, provenance files and
+index files of the folder and of its subfolders, the list of the container
+images the charts use, as listed by inspect-images, and a manifest with the
+SHA-256 checksum of every file and the version of the bundle format.`
+
+const importDesc = `Verify a bundle written by the export command and unpack it into a
+destination folder, or push its charts to a repository. Example:
+
+	helm mirror import /path/to/mirror.tar.gz /path/to/charts
+	helm mirror import /path/to/mirror.tar.gz --push-to oci://registry.local.lan/charts
+
+Every file is checked against the checksums of the bundle manifest before
+anything is written to the destination folder or pushed. The list of images
+is written to images.txt in the destination folder.`
+
+// exportCmd represents the export command
+//
+//nolint:gochecknoglobals
+var exportCmd = &cobra.Command{
+	Use:   "export [mirrored folder] [bundle file]",
+	Short: "Package a mirrored folder into a bundle file.",
+	Long:  exportDesc,
+	Args:  validateExportArgs,
+	RunE:  runExport,
+}
+
+// importCmd represents the import command
+//
+//nolint:gochecknoglobals
+var importCmd = &cobra.Command{
+	Use:   "import [bundle file] [destination folder]",
+	Short: "Verify and unpack a bundle file written by export.",
+	Long:  importDesc,
+	Args:  validateImportArgs,
+	RunE:  runImport,
+}
+
+func init() {
+	addPushFlags(importCmd)
+	rootCmd.AddCommand(exportCmd)
+	rootCmd.AddCommand(importCmd)
+}
+
+func validateExportArgs(_ *cobra.Command, args []string) error {
+	if len(args) < 2 {
+		return errors.New("error: requires a mirrored folder and a bundle file")
+	}
+
+	if !path.IsAbs(args[0]) || !path.IsAbs(args[1]) {
+		return errors.New("error: please provide full paths for the mirrored folder and the bundle file")
+	}
+
+	return nil
+}
+
+func runExport(_ *cobra.Command, args []string) error {
+	logger := log.New(os.Stderr, prefix, flags)
+
+	exportService := service.NewExportService(args[0], args[1], Verbose, IgnoreErrors, logger)
+	if err := exportService.Export(); err != nil {
+		logger.Printf("error: cannot export %q: %s", args[0], err)
+		return fmt.Errorf("cannot export the mirrored folder: %w", err)
+	}
+
+	return nil
+}
+
+func validateImportArgs(_ *cobra.Command, args []string) error {
+	if len(args) < 1 {
+		return errors.New("error: requires a bundle file")
+	}
+
+	if len(args) < 2 && pushTo == "" {
+		return errors.New("error: requires a destination folder or --push-to")
+	}
+
+	for _, arg := range args[:min(len(args), 2)] {
+		if !path.IsAbs(arg) {
+			return errors.New("error: please provide full paths for the bundle file and the destination folder")
+		}
+	}
+
+	return nil
+}
+
+func runImport(_ *cobra.Command, args []string) error {
+	logger := log.New(os.Stderr, prefix, flags)
+
+	destination := ""
+	if len(args) > 1 {
+		destination = args[1]
+	}
+
+	var publishers []service.Publisher
+	if pushTo != "" {
+		publisher, err := newPublisher(pushTo)
+		if err != nil {
+			logger.Printf("error: cannot configure push destination: %s", err)
+			return fmt.Errorf("cannot configure push destination %q: %w", pushTo, err)
+		}
+		publishers = append(publishers, publisher)
+	}
+
+	importService := service.NewImportService(args[0], destination, publishers, Verbose, IgnoreErrors, logger)
+	if err := importService.Import(); err != nil {
+		logger.Printf("error: cannot import %q: %s", args[0], err)
+		return fmt.Errorf("cannot import the bundle: %w", err)
+	}
+
+	return nil
+}
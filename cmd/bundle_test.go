@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func Test_validateExportArgs(t *testing.T) {
+	c := &cobra.Command{}
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr bool
+	}{
+		{"1", []string{}, true},
+		{"2", []string{"/charts"}, true},
+		{"3", []string{"charts", "/mirror.tar.gz"}, true},
+		{"4", []string{"/charts", "mirror.tar.gz"}, true},
+		{"5", []string{"/charts", "/mirror.tar.gz"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateExportArgs(c, tt.args); (err != nil) != tt.wantErr {
+				t.Errorf("validateExportArgs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_validateImportArgs(t *testing.T) {
+	c := &cobra.Command{}
+	tests := []struct {
+		name    string
+		args    []string
+		pushTo  string
+		wantErr bool
+	}{
+		{"1", []string{}, "", true},
+		{"2", []string{"/mirror.tar.gz"}, "", true},
+		{"3", []string{"/mirror.tar.gz"}, "oci://registry.local.lan/charts", false},
+		{"4", []string{"mirror.tar.gz", "/charts"}, "", true},
+		{"5", []string{"/mirror.tar.gz", "charts"}, "", true},
+		{"6", []string{"/mirror.tar.gz", "/charts"}, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pushTo = tt.pushTo
+			defer func() { pushTo = "" }()
+			if err := validateImportArgs(c, tt.args); (err != nil) != tt.wantErr {
+				t.Errorf("validateImportArgs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_runExportImport(t *testing.T) {
+	dir := t.TempDir()
+
+	mirror := path.Join(dir, "mirror")
+	if err := os.Mkdir(mirror, 0o755); err != nil {
+		t.Errorf("creating mirror folder: %s", err)
+	}
+	if err := os.WriteFile(path.Join(mirror, "index.yaml"), []byte("apiVersion: v1\nentries: {}\n"), 0o600); err != nil {
+		t.Errorf("writing index file: %s", err)
+	}
+
+	c := &cobra.Command{}
+	bundle := path.Join(dir, "mirror.tar.gz")
+	if err := runExport(c, []string{mirror, bundle}); err != nil {
+		t.Fatalf("runExport() error = %v", err)
+	}
+	if err := runImport(c, []string{bundle, path.Join(dir, "imported")}); err != nil {
+		t.Fatalf("runImport() error = %v", err)
+	}
+	for _, name := range []string{"index.yaml", "images.txt"} {
+		if _, err := os.Stat(path.Join(dir, "imported", name)); err != nil {
+			t.Errorf("runImport() did not write %s: %s", name, err)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+// Copyright © 2018 openSUSE opensuse-project@opensuse.org
+// Copyright © 2024 Patrick D'appollonio github@patrickdap.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"k8s.io/helm/pkg/repo"
+)
+
+// Environment variables the chart repository credentials are read from when
+// they are not given as flags
+const (
+	usernameEnv = "HELM_MIRROR_USERNAME"
+	passwordEnv = "HELM_MIRROR_PASSWORD"
+	tokenEnv    = "HELM_MIRROR_TOKEN"
+)
+
+// resolveCredentials completes config and returns the bearer token of the
+// chart repository. Each value comes from the first source setting it, in
+// this order: the flags, stdin for the password with --password-stdin, the
+// HELM_MIRROR_* environment variables, the entry of the Helm repositories.yaml
+// file named name or, without name, matching the repository URL, and finally
+// the .netrc file, looked up by host.
+func resolveCredentials(config *repo.Entry, name string, stdin io.Reader) (string, error) {
+	config.Username = username
+	config.Password = password
+	config.CAFile = caFile
+	config.CertFile = certFile
+	config.KeyFile = keyFile
+
+	if stdinPass {
+		if password != "" {
+			return "", errors.New("--password and --password-stdin cannot be used together")
+		}
+		secret, err := readPassword(stdin)
+		if err != nil {
+			return "", err
+		}
+		config.Password = secret
+	}
+
+	bearer := token
+	if bearer == "" {
+		bearer = os.Getenv(tokenEnv)
+	}
+	if config.Username == "" {
+		config.Username = os.Getenv(usernameEnv)
+	}
+	if config.Password == "" {
+		config.Password = os.Getenv(passwordEnv)
+	}
+
+	entry, err := helmRepository(name, config.URL)
+	if err != nil {
+		return "", err
+	}
+	if entry != nil {
+		if config.Username == "" && config.Password == "" && bearer == "" {
+			config.Username = entry.Username
+			config.Password = entry.Password
+		}
+		for _, file := range []struct{ value, fallback *string }{
+			{&config.CAFile, &entry.CAFile},
+			{&config.CertFile, &entry.CertFile},
+			{&config.KeyFile, &entry.KeyFile},
+		} {
+			if *file.value == "" {
+				*file.value = *file.fallback
+			}
+		}
+	}
+
+	if config.Username == "" && config.Password == "" && bearer == "" {
+		login, secret, err := netrcCredentials(config.URL)
+		if err != nil {
+			return "", err
+		}
+		config.Username = login
+		config.Password = secret
+	}
+
+	return bearer, nil
+}
+
+// readPassword reads a password from r, up to the first end of line.
+func readPassword(r io.Reader) (string, error) {
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("cannot read password from stdin: %w", err)
+	}
+
+	secret := strings.TrimRight(line, "\r\n")
+	if secret == "" {
+		return "", errors.New("no password given on stdin")
+	}
+
+	return secret, nil
+}
+
+// repositoryConfigPath returns the path of the Helm repositories.yaml file:
+// --repository-config, $HELM_REPOSITORY_CONFIG, or the default of Helm.
+func repositoryConfigPath() string {
+	if repoConfig != "" {
+		return repoConfig
+	}
+
+	if config := os.Getenv("HELM_REPOSITORY_CONFIG"); config != "" {
+		return config
+	}
+
+	return filepath.Join(helmConfigHome(), "repositories.yaml")
+}
+
+// helmConfigHome returns the folder Helm keeps its configuration in.
+func helmConfigHome() string {
+	if home := os.Getenv("HELM_CONFIG_HOME"); home != "" {
+		return home
+	}
+
+	if home := os.Getenv("XDG_CONFIG_HOME"); home != "" {
+		return filepath.Join(home, "helm")
+	}
+
+	home, _ := os.UserHomeDir()
+	switch runtime.GOOS {
+	case "darwin":
+		return filepath.Join(home, "Library", "Preferences", "helm")
+	case "windows":
+		return filepath.Join(os.Getenv("APPDATA"), "helm")
+	default:
+		return filepath.Join(home, ".config", "helm")
+	}
+}
+
+// helmRepository returns the entry of the Helm repositories.yaml file named
+// name or, without name, the one whose URL is repoURL. A missing file is only
+// an error when name or --repository-config is given.
+func helmRepository(name string, repoURL string) (*repo.Entry, error) {
+	configPath := repositoryConfigPath()
+	content, err := os.ReadFile(configPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) && name == "" && repoConfig == "" {
+			return nil, nil //nolint:nilnil // no repositories configured
+		}
+		return nil, fmt.Errorf("cannot read Helm repositories file: %w", err)
+	}
+
+	// repo.LoadRepositoriesFile rejects the files written by Helm 3, whose
+	// apiVersion is empty, so the file is parsed directly.
+	file := &repo.RepoFile{}
+	if err := yaml.Unmarshal(content, file); err != nil {
+		return nil, fmt.Errorf("cannot parse Helm repositories file %q: %w", configPath, err)
+	}
+
+	for _, entry := range file.Repositories {
+		switch {
+		case name != "" && entry.Name == name:
+			return entry, nil
+		case name == "" && strings.TrimSuffix(entry.URL, "/") == strings.TrimSuffix(repoURL, "/"):
+			return entry, nil
+		}
+	}
+
+	if name != "" {
+		return nil, fmt.Errorf("no repository named %q in %q", name, configPath)
+	}
+
+	return nil, nil //nolint:nilnil // the repository is not configured
+}
+
+// netrcPath returns the path of the .netrc file: --netrc-file, $NETRC, or
+// .netrc in the home folder.
+func netrcPath() string {
+	if netrcFile != "" {
+		return netrcFile
+	}
+
+	if name := os.Getenv("NETRC"); name != "" {
+		return name
+	}
+
+	home, _ := os.UserHomeDir()
+	if runtime.GOOS == "windows" {
+		return filepath.Join(home, "_netrc")
+	}
+
+	return filepath.Join(home, ".netrc")
+}
+
+// netrcCredentials returns the login and password of the .netrc file for the
+// host of repoURL, or of its default entry. A missing file is only an error
+// when --netrc-file is given.
+func netrcCredentials(repoURL string) (string, string, error) {
+	u, err := url.Parse(repoURL)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid repository URL %q: %w", repoURL, err)
+	}
+
+	name := netrcPath()
+	content, err := os.ReadFile(name)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) && netrcFile == "" {
+			return "", "", nil
+		}
+		return "", "", fmt.Errorf("cannot read netrc file: %w", err)
+	}
+
+	login, secret := parseNetrc(string(content), u.Hostname())
+	return login, secret, nil
+}
+
+// parseNetrc returns the login and password of the machine named host in the
+// netrc content, falling back on the default entry. Macro definitions are
+// skipped.
+func parseNetrc(content string, host string) (string, string) {
+	type machine struct{ login, password string }
+	var (
+		found, fallback *machine
+		current         *machine
+		inMacro         bool
+	)
+
+	for _, line := range strings.Split(content, "\n") {
+		if inMacro {
+			inMacro = strings.TrimSpace(line) != ""
+			continue
+		}
+
+		fields := strings.Fields(line)
+		for i := 0; i < len(fields); i++ {
+			var value string
+			if i+1 < len(fields) {
+				value = fields[i+1]
+			}
+
+			switch fields[i] {
+			case "machine":
+				current = nil
+				if found == nil && strings.EqualFold(value, host) {
+					found = &machine{}
+					current = found
+				}
+				i++
+			case "default":
+				current = nil
+				if fallback == nil {
+					fallback = &machine{}
+					current = fallback
+				}
+			case "login":
+				if current != nil {
+					current.login = value
+				}
+				i++
+			case "password":
+				if current != nil {
+					current.password = value
+				}
+				i++
+			case "account":
+				i++
+			case "macdef":
+				inMacro = true
+				i = len(fields)
+			}
+		}
+	}
+
+	switch {
+	case found != nil:
+		return found.login, found.password
+	case fallback != nil:
+		return fallback.login, fallback.password
+	default:
+		return "", ""
+	}
+}
@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s.io/helm/pkg/repo"
+)
+
+func Test_parseNetrc(t *testing.T) {
+	content := `machine other.example.com login other password secret1
+macdef init
+machine charts.example.com login macro password macro
+
+machine charts.example.com
+  login user
+  account ignored
+  password secret2
+default login anonymous password guest
+`
+	tests := []struct {
+		name         string
+		host         string
+		wantLogin    string
+		wantPassword string
+	}{
+		{"1", "charts.example.com", "user", "secret2"},
+		{"2", "OTHER.example.com", "other", "secret1"},
+		{"3", "unknown.example.com", "anonymous", "guest"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			login, password := parseNetrc(content, tt.host)
+			if login != tt.wantLogin || password != tt.wantPassword {
+				t.Errorf("parseNetrc() = %q, %q, want %q, %q", login, password, tt.wantLogin, tt.wantPassword)
+			}
+		})
+	}
+
+	if login, password := parseNetrc("machine charts.example.com login user", "other"); login != "" || password != "" {
+		t.Errorf("parseNetrc() = %q, %q, want no credentials", login, password)
+	}
+}
+
+func Test_resolveCredentials(t *testing.T) {
+	dir := t.TempDir()
+	repositories := filepath.Join(dir, "repositories.yaml")
+	if err := os.WriteFile(repositories, []byte(`apiVersion: ""
+repositories:
+- name: stable
+  url: https://charts.example.com/
+  username: helm
+  password: helm-secret
+  caFile: /certs/ca.pem
+`), 0o600); err != nil {
+		t.Errorf("writing repositories file: %s", err)
+	}
+	netrc := filepath.Join(dir, "netrc")
+	if err := os.WriteFile(netrc, []byte("machine netrc.example.com login netrc password netrc-secret\n"), 0o600); err != nil {
+		t.Errorf("writing netrc file: %s", err)
+	}
+	t.Setenv("HELM_REPOSITORY_CONFIG", repositories)
+	t.Setenv("NETRC", netrc)
+
+	tests := []struct {
+		name      string
+		url       string
+		setup     func()
+		env       map[string]string
+		stdin     string
+		want      repo.Entry
+		wantToken string
+		wantErr   bool
+	}{
+		{"1", "https://charts.example.com", nil, nil, "", repo.Entry{Username: "helm", Password: "helm-secret", CAFile: "/certs/ca.pem"}, "", false},
+		{"2", "https://charts.example.com", func() { username, password = "flag", "flag-secret" }, nil, "", repo.Entry{Username: "flag", Password: "flag-secret", CAFile: "/certs/ca.pem"}, "", false},
+		{"3", "https://charts.example.com", nil, map[string]string{usernameEnv: "env", passwordEnv: "env-secret"}, "", repo.Entry{Username: "env", Password: "env-secret", CAFile: "/certs/ca.pem"}, "", false},
+		{"4", "https://charts.example.com", func() { username, stdinPass = "flag", true }, nil, "stdin-secret\n", repo.Entry{Username: "flag", Password: "stdin-secret", CAFile: "/certs/ca.pem"}, "", false},
+		{"5", "https://charts.example.com", func() { password, stdinPass = "flag-secret", true }, nil, "stdin-secret\n", repo.Entry{}, "", true},
+		{"6", "https://charts.example.com", func() { stdinPass = true }, nil, "", repo.Entry{}, "", true},
+		{"7", "https://charts.example.com", nil, map[string]string{tokenEnv: "env-token"}, "", repo.Entry{CAFile: "/certs/ca.pem"}, "env-token", false},
+		{"8", "https://netrc.example.com", nil, nil, "", repo.Entry{Username: "netrc", Password: "netrc-secret"}, "", false},
+		{"9", "https://netrc.example.com", func() { repoName = "stable" }, nil, "", repo.Entry{Username: "helm", Password: "helm-secret", CAFile: "/certs/ca.pem"}, "", false},
+		{"10", "https://netrc.example.com", func() { repoName = "unknown" }, nil, "", repo.Entry{}, "", true},
+		{"11", "https://other.example.com", nil, nil, "", repo.Entry{}, "", false},
+		{"12", "https://other.example.com", func() { netrcFile = filepath.Join(dir, "missing") }, nil, "", repo.Entry{}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				username, password, stdinPass, repoName, netrcFile = "", "", false, "", ""
+			}()
+			if tt.setup != nil {
+				tt.setup()
+			}
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+
+			config := repo.Entry{URL: tt.url}
+			gotToken, err := resolveCredentials(&config, repoName, strings.NewReader(tt.stdin))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("resolveCredentials() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+
+			tt.want.URL = tt.url
+			if config != tt.want || gotToken != tt.wantToken {
+				t.Errorf("resolveCredentials() = %+v, %q, want %+v, %q", config, gotToken, tt.want, tt.wantToken)
+			}
+		})
+	}
+}
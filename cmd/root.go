@@ -19,10 +19,12 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"net/url"
 	"os"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/konstructio/helm-mirror/service"
 	"github.com/spf13/cobra"
@@ -48,9 +50,47 @@ var (
 	certFile     string
 	keyFile      string
 	newRootURL   string
+	concurrency  int
+	provenance   bool
+	keyring      string
+	ociCharts    []string
+	plainHTTP    bool
+	pushTo       string
+	pushConfig   repo.Entry
+	pushPlain    bool
+	pushToken    string
+	pushForce    bool
+	constraint   string
+	latest       int
+	noPrerelease bool
+	includes     []string
+	excludes     []string
+	merge        bool
+	prune        bool
+	pruneDryRun  bool
+	dryRun       bool
+	planFormat   string
+	reportPath   string
+	reportFormat string
+	retries      int
+	retryWait    time.Duration
+	connTimeout  time.Duration
+	timeout      time.Duration
+	maxRate      int64
+	maxRequests  float64
+	token        string
+	headers      []string
+	proxy        string
+	noProxy      []string
+	stdinPass    bool
+	repoConfig   string
+	repoName     string
+	netrcFile    string
+	cacheMaxAge  time.Duration
+	dependencies bool
 )
 
-const rootDesc = `Mirror Helm Charts from an index file into a local folder.
+const rootDesc = `Mirror Helm Charts from an index file or an OCI registry into a local folder.
 
 For example:
 
@@ -85,13 +125,34 @@ This will download these charts
 	https://kubernetes-charts.example.com/chart-1.0.0.tgz
 	https://kubernetes-charts.example.com/chart2-1.0.0.tgz
 
-Into your destination folder.`
+Into your destination folder.
+
+Charts stored in an OCI registry can be mirrored by passing an oci:// URL and
+the charts to pull:
+
+	helm-mirror oci://registry.example.com/charts /path/to/downloaded/charts --oci-chart nginx --oci-chart redis:17.0.0
+
+An index file listing the pulled charts is generated in the destination folder.
+
+A repository added with helm repo add can be mirrored by its name, using the
+URL, credentials and TLS files Helm keeps for it, along with its cached index
+file when it is recent enough:
+
+	helm mirror stable /path/to/downloaded/charts
+
+A chart repository on disk, such as a copy of a mirror on a USB drive or an NFS
+export, can be mirrored by passing a file:// URL or the path of its folder. The
+charts are read next to its index file, even when it points them to another
+server. A folder path without a / is only used when no Helm repository has
+that name:
+
+	helm mirror /mnt/usb/charts /path/to/downloaded/charts`
 
 // rootCmd represents the base command when called without any subcommands
 //
 //nolint:gochecknoglobals
 var rootCmd = &cobra.Command{
-	Use:   "mirror [Repo URL] [Destination Folder]",
+	Use:   "mirror [Repo URL|Repo Name|Repo Folder] [Destination Folder]",
 	Short: "Mirror Helm Charts from an index file into a local folder.",
 	Long:  rootDesc,
 	Args:  validateRootArgs,
@@ -113,11 +174,45 @@ func init() {
 	rootCmd.PersistentFlags().BoolVarP(&AllVersions, "all-versions", "a", false, "gets all the versions of the charts in the chart repository")
 	rootCmd.Flags().StringVar(&chartName, "chart-name", "", "name of the chart that gets mirrored")
 	rootCmd.Flags().StringVar(&chartVersion, "chart-version", "", "specific version of the chart that is going to be mirrored")
+	rootCmd.Flags().StringArrayVar(&includes, "include", nil, "mirror only the charts whose name matches this `pattern`, a glob or an anchored regexp prefixed with re:, can be repeated")
+	rootCmd.Flags().StringArrayVar(&excludes, "exclude", nil, "skip the charts whose name matches this `pattern`, a glob or an anchored regexp prefixed with re:, can be repeated")
+	rootCmd.Flags().StringVar(&constraint, "version-constraint", "", "mirror every version of the charts satisfying this semver constraint (eg: `>=1.2.0 <2.0.0`)")
+	rootCmd.Flags().IntVar(&latest, "latest", 0, "mirror only the latest N versions of each chart")
+	rootCmd.Flags().BoolVar(&noPrerelease, "exclude-prereleases", false, "skip prerelease versions of the charts (eg: 1.0.0-rc1)")
 	rootCmd.Flags().StringVar(&username, "username", "", "chart repository username")
 	rootCmd.Flags().StringVar(&password, "password", "", "chart repository password")
+	rootCmd.Flags().BoolVar(&stdinPass, "password-stdin", false, "read the chart repository password from stdin")
+	rootCmd.Flags().StringVar(&repoConfig, "repository-config", "", "Helm repositories.yaml `file` the credentials and TLS files are read from when not given, instead of $HELM_REPOSITORY_CONFIG")
+	rootCmd.Flags().StringVar(&repoName, "repo-name", "", "name of the entry of the Helm repositories.yaml file to read the credentials from, instead of the one with the same URL")
+	rootCmd.Flags().DurationVar(&cacheMaxAge, "cache-max-age", 30*time.Minute, "maximum age of the index file Helm caches for a repository given by name for it to be used instead of downloading the index file, 0 to always download it")
+	rootCmd.Flags().StringVar(&netrcFile, "netrc-file", "", "netrc `file` the credentials are read from when not given otherwise, instead of $NETRC or ~/.netrc")
+	rootCmd.Flags().StringVar(&token, "token", "", "bearer token sent to the chart repository instead of the username and password")
+	rootCmd.Flags().StringArrayVar(&headers, "header", nil, "header added to the requests to the chart repository, as `name: value`, can be repeated")
+	rootCmd.Flags().StringVar(&proxy, "proxy", "", "send the requests to the chart repository through this HTTP(S) proxy instead of the one of HTTP_PROXY and HTTPS_PROXY (eg: `http://proxy.local.lan:3128`)")
+	rootCmd.Flags().StringSliceVar(&noProxy, "no-proxy", nil, "comma separated `hosts` reached without a proxy, as domains, IP addresses or CIDR ranges, instead of the ones of NO_PROXY")
 	rootCmd.Flags().StringVar(&caFile, "ca-file", "", "verify certificates of HTTPS-enabled servers using this CA bundle")
 	rootCmd.Flags().StringVar(&certFile, "cert-file", "", "identify HTTPS client using this SSL certificate file")
 	rootCmd.Flags().StringVar(&keyFile, "key-file", "", "identify HTTPS client using this SSL key file")
+	rootCmd.Flags().IntVar(&concurrency, "concurrency", 1, "number of charts downloaded in parallel")
+	rootCmd.Flags().IntVar(&retries, "retries", 0, "number of times the index file and chart downloads failing with a transient error are retried")
+	rootCmd.Flags().DurationVar(&retryWait, "retry-wait", time.Second, "initial delay between retries, doubled on each retry unless the server sends Retry-After")
+	rootCmd.Flags().DurationVar(&connTimeout, "connect-timeout", 30*time.Second, "maximum time spent establishing a connection to the chart repository, 0 for no limit")
+	rootCmd.Flags().DurationVar(&timeout, "timeout", 0, "maximum time spent on each download from the chart repository, 0 for no limit")
+	rootCmd.Flags().Int64Var(&maxRate, "max-rate", 0, "maximum download bandwidth from the chart repository in bytes per second, across all downloads, 0 for no limit")
+	rootCmd.Flags().Float64Var(&maxRequests, "max-requests-per-second", 0, "maximum number of requests per second sent to the chart repository, across all downloads, 0 for no limit")
+	rootCmd.Flags().BoolVar(&dependencies, "dependencies", false, "also mirror the charts the mirrored charts depend on, transitively, into a subfolder per repository")
+	rootCmd.Flags().BoolVar(&provenance, "provenance", false, "mirror the provenance (.prov) file published next to each chart")
+	rootCmd.Flags().StringVar(&keyring, "keyring", "", "verify chart signatures using the public keys in this keyring, implies --provenance")
+	rootCmd.Flags().StringArrayVar(&ociCharts, "oci-chart", nil, "chart to pull from an oci:// repository, as `name[:tag]`, can be repeated")
+	rootCmd.Flags().BoolVar(&plainHTTP, "plain-http", false, "use insecure HTTP connections to OCI registries")
+	addPushFlags(rootCmd)
+	rootCmd.Flags().BoolVar(&merge, "merge", false, "keep the charts listed in the existing index file of the destination folder whose archive is still there")
+	rootCmd.Flags().BoolVar(&prune, "prune", false, "delete the charts of the destination folder that are not part of the mirrored charts anymore")
+	rootCmd.Flags().BoolVar(&pruneDryRun, "prune-dry-run", false, "list the charts --prune would delete without deleting them")
+	rootCmd.Flags().BoolVar(&dryRun, "dry-run", false, "print the charts that would be downloaded, skipped as up to date or pruned, without downloading any chart")
+	rootCmd.Flags().StringVar(&planFormat, "plan-format", string(service.PlanText), "format of the --dry-run plan: `text` or json")
+	rootCmd.Flags().StringVar(&reportPath, "report", "", "write a report of every chart version attempted, with its outcome, to this `file`")
+	rootCmd.Flags().StringVar(&reportFormat, "report-format", string(service.ReportJSON), "format of the --report file: `json` or junit")
 	rootCmd.Flags().StringVar(&newRootURL, "new-root-url", "", "New root url of the chart repository (eg: `https://mirror.local.lan/charts`)")
 	rootCmd.AddCommand(newVersionCmd())
 }
@@ -135,7 +230,8 @@ func validateRootArgs(_ *cobra.Command, args []string) error {
 		return fmt.Errorf("error: %q is not a valid URL for index file: %w", args[0], err)
 	}
 
-	if !strings.Contains(url.Scheme, "http") {
+	local := url.Scheme == "file" || url.Scheme == "" && !isRepoAlias(args[0])
+	if !strings.Contains(url.Scheme, "http") && url.Scheme != "oci" && !local && !isRepoAlias(args[0]) {
 		return errors.New("error: not a valid URL protocol")
 	}
 
@@ -146,7 +242,7 @@ func validateRootArgs(_ *cobra.Command, args []string) error {
 	return nil
 }
 
-func runRoot(_ *cobra.Command, args []string) error {
+func runRoot(cmd *cobra.Command, args []string) error {
 	logger := log.New(os.Stderr, prefix, flags)
 
 	repoURL, err := url.Parse(args[0])
@@ -155,10 +251,26 @@ func runRoot(_ *cobra.Command, args []string) error {
 		return fmt.Errorf("error: %q is not a valid URL for index file: %w", args[0], err)
 	}
 
+	entryName, cachedIndex := repoName, ""
+	if isRepoAlias(args[0]) {
+		aliasURL, aliasIndex, err := resolveRepoAlias(args[0])
+		switch {
+		case err == nil:
+			entryName, repoURL, cachedIndex = args[0], aliasURL, aliasIndex
+		case isDir(args[0]):
+			logger.Printf("No Helm repository named %q, mirroring the folder of that name", args[0])
+		default:
+			logger.Printf("error: cannot resolve Helm repository %q: %s", args[0], err)
+			return fmt.Errorf("error: cannot resolve Helm repository %q: %w", args[0], err)
+		}
+	}
+
 	folder = args[1]
-	if err := os.MkdirAll(folder, 0o744); err != nil {
-		logger.Printf("error: cannot create destination folder: %s", err)
-		return fmt.Errorf("cannot create destination folder %q: %w", folder, err)
+	if !dryRun {
+		if err := os.MkdirAll(folder, 0o744); err != nil {
+			logger.Printf("error: cannot create destination folder: %s", err)
+			return fmt.Errorf("cannot create destination folder %q: %w", folder, err)
+		}
 	}
 
 	rootURL := &url.URL{}
@@ -180,20 +292,174 @@ func runRoot(_ *cobra.Command, args []string) error {
 		return errors.New("error: chart Version depends on a chart name, please specify one")
 	}
 
+	if merge && (prune || pruneDryRun) {
+		logger.Printf("error: --merge and --prune cannot be used together")
+		return errors.New("error: --merge and --prune cannot be used together")
+	}
+
+	if planFormat != string(service.PlanText) && planFormat != string(service.PlanJSON) {
+		logger.Printf("error: not a valid plan format: %q", planFormat)
+		return fmt.Errorf("error: %q is not a valid plan format, use text or json", planFormat)
+	}
+
+	if reportFormat != string(service.ReportJSON) && reportFormat != string(service.ReportJUnit) {
+		logger.Printf("error: not a valid report format: %q", reportFormat)
+		return fmt.Errorf("error: %q is not a valid report format, use json or junit", reportFormat)
+	}
+
+	requestHeaders, err := parseHeaders(headers)
+	if err != nil {
+		logger.Printf("error: %s", err)
+		return fmt.Errorf("error: %w", err)
+	}
+
+	if repoURL.Scheme == "oci" && len(ociCharts) == 0 {
+		logger.Printf("error: an oci:// repository requires at least one --oci-chart")
+		return errors.New("error: an oci:// repository requires at least one --oci-chart")
+	}
+
+	if repoURL.Scheme == "oci" && (provenance || keyring != "") {
+		logger.Printf("error: --provenance and --keyring cannot be used with an oci:// repository")
+		return errors.New("error: --provenance and --keyring cannot be used with an oci:// repository")
+	}
+
 	config := repo.Entry{
-		Name:     folder,
-		URL:      repoURL.String(),
-		Username: username,
-		Password: password,
-		CAFile:   caFile,
-		CertFile: certFile,
-		KeyFile:  keyFile,
+		Name: folder,
+		URL:  repoURL.String(),
+	}
+	bearer, err := resolveCredentials(&config, entryName, cmd.InOrStdin())
+	if err != nil {
+		logger.Printf("error: cannot resolve the chart repository credentials: %s", err)
+		return fmt.Errorf("cannot resolve the chart repository credentials: %w", err)
+	}
+
+	opts := []service.GetOption{
+		service.WithConcurrency(concurrency),
+		service.WithRetries(retries, retryWait),
+		service.WithTimeouts(connTimeout, timeout),
+		service.WithRateLimits(maxRate, maxRequests),
+	}
+	if cachedIndex != "" {
+		opts = append(opts, service.WithCachedIndex(cachedIndex, cacheMaxAge))
+	}
+	if bearer != "" {
+		opts = append(opts, service.WithBearerToken(bearer))
+	}
+	if len(requestHeaders) > 0 {
+		opts = append(opts, service.WithHeaders(requestHeaders))
+	}
+	if proxy != "" || len(noProxy) > 0 {
+		opts = append(opts, service.WithProxy(proxy, noProxy))
+	}
+	if provenance || keyring != "" {
+		opts = append(opts, service.WithProvenance(keyring))
+	}
+	if constraint != "" {
+		opts = append(opts, service.WithVersionConstraint(constraint))
+	}
+	if len(includes) > 0 {
+		opts = append(opts, service.WithIncludes(includes))
+	}
+	if len(excludes) > 0 {
+		opts = append(opts, service.WithExcludes(excludes))
+	}
+	if latest > 0 {
+		opts = append(opts, service.WithLatest(latest))
+	}
+	if noPrerelease {
+		opts = append(opts, service.WithoutPrereleases())
+	}
+	if merge {
+		opts = append(opts, service.WithMerge())
+	}
+	if dependencies {
+		opts = append(opts, service.WithDependencies())
+	}
+	if prune || pruneDryRun {
+		opts = append(opts, service.WithPrune(pruneDryRun))
+	}
+	if reportPath != "" {
+		opts = append(opts, service.WithReport(reportPath, service.ReportFormat(reportFormat)))
+	}
+	if dryRun {
+		opts = append(opts, service.WithDryRun(os.Stdout, service.PlanFormat(planFormat)))
+	}
+	if len(ociCharts) > 0 {
+		opts = append(opts, service.WithOCICharts(ociCharts))
+	}
+	if plainHTTP {
+		opts = append(opts, service.WithPlainHTTP())
+	}
+	if pushTo != "" {
+		publisher, err := newPublisher(pushTo)
+		if err != nil {
+			logger.Printf("error: cannot configure push destination: %s", err)
+			return fmt.Errorf("cannot configure push destination %q: %w", pushTo, err)
+		}
+		opts = append(opts, service.WithPublisher(publisher))
 	}
 
-	getService := service.NewGetService(config, AllVersions, Verbose, IgnoreErrors, logger, rootURL.String(), chartName, chartVersion)
+	getService := service.NewGetService(config, AllVersions, Verbose, IgnoreErrors, logger, rootURL.String(), chartName, chartVersion, opts...)
 	if err := getService.Get(); err != nil {
 		return fmt.Errorf("cannot download index and charts to the specified directory: %w", err)
 	}
 
 	return nil
 }
+
+// parseHeaders parses headers given as "name: value".
+func parseHeaders(values []string) (http.Header, error) {
+	headers := http.Header{}
+	for _, value := range values {
+		name, content, ok := strings.Cut(value, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("%q is not a valid header, use name: value", value)
+		}
+		headers.Add(name, strings.TrimSpace(content))
+	}
+
+	return headers, nil
+}
+
+// addPushFlags adds the flags configuring the repository charts are pushed
+// to to cmd.
+func addPushFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&pushTo, "push-to", "", "push every mirrored chart to this OCI registry or ChartMuseum server (eg: `oci://registry.local.lan/charts`)")
+	cmd.Flags().StringVar(&pushConfig.Username, "push-username", "", "username of the repository charts are pushed to")
+	cmd.Flags().StringVar(&pushConfig.Password, "push-password", "", "password of the repository charts are pushed to")
+	cmd.Flags().StringVar(&pushConfig.CAFile, "push-ca-file", "", "verify certificates of the repository charts are pushed to using this CA bundle")
+	cmd.Flags().StringVar(&pushConfig.CertFile, "push-cert-file", "", "identify to the repository charts are pushed to using this SSL certificate file")
+	cmd.Flags().StringVar(&pushConfig.KeyFile, "push-key-file", "", "identify to the repository charts are pushed to using this SSL key file")
+	cmd.Flags().BoolVar(&pushPlain, "push-plain-http", false, "use insecure HTTP connections to the registry charts are pushed to")
+	cmd.Flags().StringVar(&pushToken, "push-token", "", "bearer token sent to the ChartMuseum server charts are pushed to")
+	cmd.Flags().BoolVar(&pushForce, "push-force", false, "overwrite charts that already exist in the ChartMuseum server charts are pushed to")
+}
+
+//nolint:ireturn
+func newPublisher(destination string) (service.Publisher, error) {
+	destURL, err := url.Parse(destination)
+	if err != nil {
+		return nil, fmt.Errorf("not a valid URL: %w", err)
+	}
+
+	config := pushConfig
+	config.URL = destination
+
+	switch destURL.Scheme {
+	case "oci":
+		publisher, err := service.NewOCIPublisher(config, pushPlain)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create OCI publisher: %w", err)
+		}
+		return publisher, nil
+	case "http", "https":
+		publisher, err := service.NewChartMuseumPublisher(config, pushToken, pushForce)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create ChartMuseum publisher: %w", err)
+		}
+		return publisher, nil
+	default:
+		return nil, errors.New("only oci:// and http(s):// destinations are supported")
+	}
+}
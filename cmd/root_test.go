@@ -3,9 +3,11 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"os"
 	"path"
 	"path/filepath"
+	"reflect"
 	"testing"
 
 	"github.com/konstructio/helm-mirror/fixtures"
@@ -37,6 +39,14 @@ func Test_validateRootArgs(t *testing.T) {
 		{"6", args{c, []string{"ftps://url", "/target", "extra"}}, true},
 		{"7", args{c, []string{"help"}}, false},
 		{"8", args{c, []string{"%", "/target", "extra"}}, true},
+		{"9.1", args{c, []string{"oci://registry/charts", "target"}}, true},
+		{"9.2", args{c, []string{"oci://registry/charts", "/target"}}, false},
+		{"10.1", args{c, []string{"stable", "/target"}}, false},
+		{"10.2", args{c, []string{"stable", "target"}}, true},
+		{"11.1", args{c, []string{"file:///mnt/charts", "/target"}}, false},
+		{"11.2", args{c, []string{"/mnt/charts", "/target"}}, false},
+		{"11.3", args{c, []string{"./charts", "/target"}}, false},
+		{"11.4", args{c, []string{"/mnt/charts", "target"}}, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -48,11 +58,7 @@ func Test_validateRootArgs(t *testing.T) {
 }
 
 func Test_runRoot(t *testing.T) {
-	dir, err := os.MkdirTemp("", "helmmirror")
-	if err != nil {
-		t.Errorf("creating temp dir: %s", err)
-	}
-	defer os.RemoveAll(dir)
+	dir := t.TempDir()
 	svr := fixtures.StartHTTPServer()
 	defer svr.Shutdown(context.Background())
 	fixtures.WaitForServer("http://127.0.0.1:1793/alive")
@@ -81,6 +87,7 @@ func Test_runRoot(t *testing.T) {
 		{"9", args{&cobra.Command{}, []string{"http://127.0.0.1:1793", dir}, "", false, true, "", ""}, true},
 		{"10", args{&cobra.Command{}, []string{"http://127.0.0.1:1793", dir}, "", true, false, "", ""}, false},
 		{"11", args{&cobra.Command{}, []string{"http://127.0.0.1:1793", dir}, "", true, false, "", "1.0.0"}, true},
+		{"12", args{&cobra.Command{}, []string{"oci://127.0.0.1:1793/charts", dir}, "", true, false, "", ""}, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -97,3 +104,114 @@ func Test_runRoot(t *testing.T) {
 		})
 	}
 }
+
+func Test_runRootMergeAndPrune(t *testing.T) {
+	merge, prune = true, true
+	defer func() { merge, prune = false, false }()
+	if err := runRoot(&cobra.Command{}, []string{"http://test", os.TempDir()}); err == nil {
+		t.Errorf("runRoot() error = nil, want --merge and --prune to be rejected")
+	}
+}
+
+func Test_runRootOCIProvenance(t *testing.T) {
+	ociCharts, keyring = []string{"nginx"}, "/keys/pubring.gpg"
+	defer func() { ociCharts, keyring = nil, "" }()
+	if err := runRoot(&cobra.Command{}, []string{"oci://registry.example.com/charts", os.TempDir()}); err == nil {
+		t.Errorf("runRoot() error = nil, want --keyring to be rejected with an oci:// repository")
+	}
+}
+
+func Test_runRootLocalFolder(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "charts"), 0o755); err != nil {
+		t.Errorf("creating repository folder: %s", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "charts", "index.yaml"), []byte("apiVersion: v1\nentries: {}\n"), 0o600); err != nil {
+		t.Errorf("writing index file: %s", err)
+	}
+	t.Setenv("HELM_REPOSITORY_CONFIG", filepath.Join(dir, "repositories.yaml"))
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Errorf("getting working directory: %s", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Errorf("changing working directory: %s", err)
+	}
+	defer os.Chdir(wd)
+
+	destination := filepath.Join(dir, "mirror")
+	if err := runRoot(&cobra.Command{}, []string{"charts", destination}); err != nil {
+		t.Errorf("runRoot() error = %v, want the charts folder to be mirrored", err)
+	}
+	if _, err := os.Stat(filepath.Join(destination, "index.yaml")); err != nil {
+		t.Errorf("runRoot() did not write the index file: %s", err)
+	}
+	if err := runRoot(&cobra.Command{}, []string{"missing", destination}); err == nil {
+		t.Errorf("runRoot() error = nil, want an unknown repository name to be rejected")
+	}
+}
+
+func Test_runRootPlanFormat(t *testing.T) {
+	planFormat = "xml"
+	defer func() { planFormat = "text" }()
+	if err := runRoot(&cobra.Command{}, []string{"http://test", os.TempDir()}); err == nil {
+		t.Errorf("runRoot() error = nil, want an invalid plan format to be rejected")
+	}
+}
+
+func Test_runRootReportFormat(t *testing.T) {
+	reportFormat = "html"
+	defer func() { reportFormat = "json" }()
+	if err := runRoot(&cobra.Command{}, []string{"http://test", os.TempDir()}); err == nil {
+		t.Errorf("runRoot() error = nil, want an invalid report format to be rejected")
+	}
+}
+
+func Test_parseHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  []string
+		want    http.Header
+		wantErr bool
+	}{
+		{"1", nil, http.Header{}, false},
+		{"2", []string{"X-JFrog-Art-Api: key", "accept:application/json"}, http.Header{"X-Jfrog-Art-Api": {"key"}, "Accept": {"application/json"}}, false},
+		{"3", []string{"X-Test: a", "X-Test: b:c"}, http.Header{"X-Test": {"a", "b:c"}}, false},
+		{"4", []string{"X-Test"}, nil, true},
+		{"5", []string{": value"}, nil, true},
+		{"6", []string{"X Test: value"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseHeaders(tt.values)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseHeaders() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseHeaders() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_newPublisher(t *testing.T) {
+	tests := []struct {
+		name        string
+		destination string
+		wantErr     bool
+	}{
+		{"1", "oci://registry.local.lan/charts", false},
+		{"2", "oci://", true},
+		{"3", "ftp://registry.local.lan/charts", true},
+		{"4", "%", true},
+		{"5", "https://chartmuseum.local.lan", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newPublisher(tt.destination); (err != nil) != tt.wantErr {
+				t.Errorf("newPublisher() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright © 2018 openSUSE opensuse-project@opensuse.org
+// Copyright © 2024 Patrick D'appollonio github@patrickdap.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+
+	"github.com/konstructio/helm-mirror/service"
+	"github.com/spf13/cobra"
+)
+
+const syncDesc = `Mirror every chart repository listed in a manifest file, each one
+into its own subfolder of the destination folder. Example:
+
+	helm mirror sync /path/to/mirror.yaml /path/to/charts
+
+The manifest is a YAML file listing the repositories, with the same settings
+as the flags of the mirror command. Example:
+
+	repositories:
+	- name: stable
+	  url: https://charts.example.com
+	  username: ${STABLE_USERNAME}
+	  password: ${STABLE_PASSWORD}
+	  charts: [nginx, redis]
+	  versionConstraint: ">=1.2.0 <2.0.0"
+	- name: registry
+	  url: oci://registry.example.com/charts
+	  folder: oci/registry
+	  caFile: certs/ca.pem
+	  charts: [nginx, redis:17.0.0]
+	  latest: 3
+
+Environment variables are expanded in usernames, passwords, tokens and header
+values, and relative file paths are resolved against the folder holding the
+manifest.`
+
+// syncCmd represents the sync command
+//
+//nolint:gochecknoglobals
+var syncCmd = &cobra.Command{
+	Use:   "sync [manifest] [destination folder]",
+	Short: "Mirror every chart repository listed in a manifest file.",
+	Long:  syncDesc,
+	Args:  validateSyncArgs,
+	RunE:  runSync,
+}
+
+func init() {
+	rootCmd.AddCommand(syncCmd)
+}
+
+func validateSyncArgs(_ *cobra.Command, args []string) error {
+	if len(args) < 2 {
+		return errors.New("error: requires a manifest and a destination folder")
+	}
+
+	if !path.IsAbs(args[1]) {
+		return errors.New("error: please provide a full path for destination folder")
+	}
+
+	return nil
+}
+
+func runSync(_ *cobra.Command, args []string) error {
+	logger := log.New(os.Stderr, prefix, flags)
+
+	manifest, err := service.LoadManifest(args[0])
+	if err != nil {
+		logger.Printf("error: cannot load manifest: %s", err)
+		return fmt.Errorf("cannot load manifest: %w", err)
+	}
+
+	manifestService := service.NewManifestService(manifest, args[1], Verbose, IgnoreErrors, logger)
+	if err := manifestService.Get(); err != nil {
+		return fmt.Errorf("cannot mirror the repositories of the manifest: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/konstructio/helm-mirror/fixtures"
+	"github.com/spf13/cobra"
+)
+
+func Test_validateSyncArgs(t *testing.T) {
+	c := &cobra.Command{}
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr bool
+	}{
+		{"1", []string{}, true},
+		{"2", []string{"mirror.yaml"}, true},
+		{"3", []string{"mirror.yaml", "destination"}, true},
+		{"4", []string{"mirror.yaml", "/destination"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateSyncArgs(c, tt.args); (err != nil) != tt.wantErr {
+				t.Errorf("validateSyncArgs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_runSync(t *testing.T) {
+	svr := fixtures.NewTestRepositoryServer(t)
+	dir := t.TempDir()
+
+	manifest := path.Join(dir, "mirror.yaml")
+	content := "repositories:\n- name: fixtures\n  url: " + svr.URL + "\n  charts: [chart1]\n"
+	if err := os.WriteFile(manifest, []byte(content), 0o600); err != nil {
+		t.Errorf("writing manifest: %s", err)
+	}
+
+	c := &cobra.Command{}
+	tests := []struct {
+		name     string
+		args     []string
+		wantErr  bool
+		wantFile string
+	}{
+		{"1", []string{path.Join(dir, "missing.yaml"), path.Join(dir, "charts")}, true, ""},
+		{"2", []string{manifest, path.Join(dir, "charts")}, false, path.Join(dir, "charts", "fixtures", "chart1-2.11.0.tgz")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := runSync(c, tt.args); (err != nil) != tt.wantErr {
+				t.Errorf("runSync() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantFile != "" {
+				if _, err := os.Stat(tt.wantFile); err != nil {
+					t.Errorf("runSync() did not mirror %s: %s", tt.wantFile, err)
+				}
+			}
+		})
+	}
+}
@@ -60,6 +60,12 @@ func chartTgz(w http.ResponseWriter, r *http.Request) {
 	w.Write(chartTGZ)
 }
 
+// ChartTGZ returns the chart archive served for every chart by the test
+// servers
+func ChartTGZ() []byte {
+	return chartTGZ
+}
+
 var chartTGZ = []byte{
 	31, 139, 8, 0, 224, 223, 181, 91, 0, 3, 237, 193, 1, 13, 0, 0, 0, 194,
 	160, 247, 79, 109, 14, 55, 160, 0, 0, 0, 0, 0, 0, 0, 0, 0, 128, 55, 3, 154, 222, 29, 39, 0, 40, 0, 0,
@@ -75,7 +81,7 @@ entries:
   - apiVersion: v2
     created: 2018-09-20T00:00:00.000000000Z
     description: A Helm chart for testing
-    digest: 8cc99f9cb669171776f7c6ec66069907579be91179f9201725fc6fc6f9ef1f29
+    digest: b4c995c50759e4ee1cd83e5e230c21895522546b0902f359a4a82d1d7421128a
     name: chart1
     urls:
     - http://127.0.0.1:1793/chart1-2.11.0.tgz
@@ -84,7 +90,7 @@ entries:
   - apiVersion: v1
     created: 2018-10-20T00:00:00.000000000Z
     description: A Helm chart for testing too
-    digest: 0c76ee9b4b78cb60fcce8c00ec0f5048cbe626fcaabe48f2f8e84b029e894f49
+    digest: b4c995c50759e4ee1cd83e5e230c21895522546b0902f359a4a82d1d7421128a
     name: chart2
     urls:
     - http://127.0.0.1:1793/chart2-1.0.1.tgz
@@ -92,7 +98,7 @@ entries:
   - apiVersion: v1
     created: 2018-09-20T00:00:00.000000000Z
     description: A Helm chart for testing too
-    digest: e9a545006570b7fc5e4458f6eae178c2aa8f8e9e57eafac59869c856b86e862f
+    digest: b4c995c50759e4ee1cd83e5e230c21895522546b0902f359a4a82d1d7421128a
     name: chart2
     urls:
     - http://127.0.0.1:1793/chart2-0.0.0-rc1.tgz
@@ -101,7 +107,7 @@ entries:
   - apiVersion: v1
     created: 2018-12-18T00:00:00.000000000Z
     description: A Helm chart that does exist
-    digest: f9a848106870c7fc8f4488f6faf178c2aa8f8f9f87fafac89819c886c86e862f
+    digest: b4c995c50759e4ee1cd83e5e230c21895522546b0902f359a4a82d1d7421128a
     name: chart3
     urls:
     - http://127.0.0.1:1793/chart3-0.0.1-rc1.tgz
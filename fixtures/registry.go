@@ -0,0 +1,233 @@
+package fixtures
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// RegistryToken is the bearer token handed out by the Registry token endpoint
+const RegistryToken = "registry-test-token" //nolint:gosec // fake token of the test registry
+
+// Registry is an in-memory stand-in for an OCI distribution registry holding
+// Helm charts. When Username is set, every /v2/ request requires a bearer
+// token obtained from /token with basic auth. When PageSize is set, tag lists
+// are split in pages of that size linked with Link headers.
+type Registry struct {
+	Username string
+	Password string
+	PageSize int
+
+	mu        sync.Mutex
+	blobs     map[string][]byte
+	manifests map[string]map[string][]byte
+	uploads   int
+}
+
+// NewRegistry returns an empty Registry
+func NewRegistry(username, password string) *Registry {
+	return &Registry{
+		Username:  username,
+		Password:  password,
+		blobs:     map[string][]byte{},
+		manifests: map[string]map[string][]byte{},
+	}
+}
+
+// AddChart stores a chart archive and its JSON metadata in repository under tag
+func (r *Registry) AddChart(repository, tag string, chart []byte, config []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	configDigest := r.addBlob(config)
+	chartDigest := r.addBlob(chart)
+	manifest := fmt.Sprintf(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json",`+
+		`"config":{"mediaType":"application/vnd.cncf.helm.config.v1+json","digest":%q,"size":%d},`+
+		`"layers":[{"mediaType":"application/vnd.cncf.helm.chart.content.v1.tar+gzip","digest":%q,"size":%d}]}`,
+		configDigest, len(config), chartDigest, len(chart))
+	if r.manifests[repository] == nil {
+		r.manifests[repository] = map[string][]byte{}
+	}
+	r.manifests[repository][tag] = []byte(manifest)
+}
+
+func (r *Registry) addBlob(content []byte) string {
+	sum := sha256.Sum256(content)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	r.blobs[digest] = content
+	return digest
+}
+
+// ServeHTTP implements http.Handler
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/token" {
+		r.serveToken(w, req)
+		return
+	}
+
+	if !strings.HasPrefix(req.URL.Path, "/v2/") {
+		http.NotFound(w, req)
+		return
+	}
+
+	if r.Username != "" && req.Header.Get("Authorization") != "Bearer "+RegistryToken {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="http://%s/token",service="registry",scope="repository:helm:pull"`, req.Host))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	name := strings.TrimPrefix(req.URL.Path, "/v2/")
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch {
+	case name == "":
+		w.WriteHeader(http.StatusOK)
+	case strings.HasSuffix(name, "/tags/list"):
+		r.serveTags(w, req, strings.TrimSuffix(name, "/tags/list"))
+	case strings.Contains(name, "/manifests/") && req.Method == http.MethodPut:
+		i := strings.LastIndex(name, "/manifests/")
+		r.putManifest(w, req, name[:i], name[i+len("/manifests/"):])
+	case strings.Contains(name, "/manifests/"):
+		i := strings.LastIndex(name, "/manifests/")
+		r.serveManifest(w, req, name[:i], name[i+len("/manifests/"):])
+	case strings.Contains(name, "/blobs/uploads/") && req.Method == http.MethodPost:
+		r.uploads++
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/upload-%d", strings.TrimSuffix(name, "/blobs/uploads/"), r.uploads))
+		w.WriteHeader(http.StatusAccepted)
+	case strings.Contains(name, "/blobs/upload-") && req.Method == http.MethodPut:
+		r.putBlob(w, req)
+	case strings.Contains(name, "/blobs/"):
+		i := strings.LastIndex(name, "/blobs/")
+		r.serveBlob(w, req, name[i+len("/blobs/"):])
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func (r *Registry) serveToken(w http.ResponseWriter, req *http.Request) {
+	username, password, _ := req.BasicAuth()
+	if username != r.Username || password != r.Password {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = fmt.Fprintf(w, `{"token":%q}`, RegistryToken)
+}
+
+func (r *Registry) serveTags(w http.ResponseWriter, req *http.Request, repository string) {
+	manifests, ok := r.manifests[repository]
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	tags := make([]string, 0, len(manifests))
+	for tag := range manifests {
+		if !strings.HasPrefix(tag, "sha256:") {
+			tags = append(tags, tag)
+		}
+	}
+	sort.Strings(tags)
+	if last := req.URL.Query().Get("last"); last != "" {
+		i := sort.SearchStrings(tags, last)
+		if i < len(tags) && tags[i] == last {
+			i++
+		}
+		tags = tags[i:]
+	}
+	if r.PageSize > 0 && len(tags) > r.PageSize {
+		tags = tags[:r.PageSize]
+		w.Header().Set("Link", fmt.Sprintf(`</v2/%s/tags/list?n=%d&last=%s>; rel="next"`, repository, r.PageSize, url.QueryEscape(tags[len(tags)-1])))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]any{"name": repository, "tags": tags}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (r *Registry) serveManifest(w http.ResponseWriter, req *http.Request, repository, reference string) {
+	manifest, ok := r.manifests[repository][reference]
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+	_, _ = w.Write(manifest)
+}
+
+func (r *Registry) serveBlob(w http.ResponseWriter, req *http.Request, digest string) {
+	blob, ok := r.blobs[digest]
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	_, _ = w.Write(blob)
+}
+
+func (r *Registry) putBlob(w http.ResponseWriter, req *http.Request) {
+	content, err := io.ReadAll(req.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if digest := r.addBlob(content); digest != req.URL.Query().Get("digest") {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (r *Registry) putManifest(w http.ResponseWriter, req *http.Request, repository, reference string) {
+	content, err := io.ReadAll(req.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var manifest struct {
+		Config struct {
+			Digest string `json:"digest"`
+		} `json:"config"`
+		Layers []struct {
+			Digest string `json:"digest"`
+		} `json:"layers"`
+	}
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	digests := []string{manifest.Config.Digest}
+	for _, layer := range manifest.Layers {
+		digests = append(digests, layer.Digest)
+	}
+	for _, digest := range digests {
+		if _, ok := r.blobs[digest]; !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	if r.manifests[repository] == nil {
+		r.manifests[repository] = map[string][]byte{}
+	}
+	r.manifests[repository][reference] = content
+	w.WriteHeader(http.StatusCreated)
+}
+
+// Tags returns the tags pushed to repository
+func (r *Registry) Tags(repository string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	tags := []string{}
+	for tag := range r.manifests[repository] {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags
+}
@@ -0,0 +1,62 @@
+package fixtures
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// NewTestRepositoryServer starts a chart repository like NewRepositoryServer
+// and stops it when the test t and its subtests are done.
+func NewTestRepositoryServer(t testing.TB) *httptest.Server {
+	t.Helper()
+
+	srv := NewRepositoryServer()
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+// NewRepositoryServer starts a chart repository serving IndexYaml and its
+// charts on a random port, with the chart URLs rewritten to point to it.
+func NewRepositoryServer() *httptest.Server {
+	return NewFlakyRepositoryServer(0)
+}
+
+// NewFlakyRepositoryServer starts a chart repository like
+// NewRepositoryServer, except that every file is answered with a
+// 503 Service Unavailable the first failures times it is requested.
+func NewFlakyRepositoryServer(failures int) *httptest.Server {
+	var (
+		srv      *httptest.Server
+		mu       sync.Mutex
+		requests = map[string]int{}
+	)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/index.yaml", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "binary/octet-stream")
+		_, _ = w.Write([]byte(strings.ReplaceAll(IndexYaml, "http://127.0.0.1:1793", srv.URL)))
+	})
+	mux.HandleFunc("/chart1-2.11.0.tgz", chartTgz)
+	mux.HandleFunc("/chart2-1.0.1.tgz", chartTgz)
+	mux.HandleFunc("/chart2-0.0.0-rc1.tgz", chartTgz)
+	mux.HandleFunc("/chart3-0.0.1-rc1.tgz", chartTgz)
+
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		failing := requests[r.URL.Path] <= failures
+		mu.Unlock()
+
+		if failing {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	return srv
+}
@@ -0,0 +1,564 @@
+package service
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/helm/pkg/chartutil"
+)
+
+const (
+	// BundleVersion is the version of the bundle format written by
+	// ExportService and read by ImportService
+	BundleVersion = 1
+
+	bundleManifestName = "bundle.json"
+	bundleImagesName   = "images.txt"
+	bundleChartsFolder = "charts"
+	maxBundleManifest  = 16 << 20
+)
+
+// BundleManifest describes the content of a bundle: it is its first file, and
+// lists the checksum of every other one.
+type BundleManifest struct {
+	Version int          `json:"version"`
+	Created time.Time    `json:"created"`
+	Files   []BundleFile `json:"files"`
+}
+
+// BundleFile is a file of a bundle, with its path in the bundle, its size
+// and its SHA-256 digest
+type BundleFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Digest string `json:"sha256"`
+}
+
+// ExportService packages a mirrored folder into a bundle
+type ExportService struct {
+	source       string
+	bundle       string
+	verbose      bool
+	ignoreErrors bool
+	logger       *log.Logger
+}
+
+// NewExportService return a new instance of ExportService packaging the
+// mirror in source into the bundle file at bundle
+func NewExportService(source string, bundle string, verbose bool, ignoreErrors bool, logger *log.Logger) *ExportService {
+	return &ExportService{
+		source:       source,
+		bundle:       bundle,
+		verbose:      verbose,
+		ignoreErrors: ignoreErrors,
+		logger:       logger,
+	}
+}
+
+// Export writes the bundle: a gzipped tar archive holding the manifest of
+// the bundle, the list of the container images used by the charts, as listed
+// by inspect-images, and the charts, provenance files and index files of the
+// mirror under charts/, subfolders included.
+func (e *ExportService) Export() error {
+	names, err := mirrorFiles(e.source)
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("no charts or index files found in %q", e.source)
+	}
+
+	images, err := e.images(names)
+	if err != nil {
+		return err
+	}
+
+	manifest := &BundleManifest{Version: BundleVersion, Created: time.Now().UTC()}
+	manifest.Files = append(manifest.Files, bundleFile(bundleImagesName, images))
+	for _, name := range names {
+		file, err := fileDigest(filepath.Join(e.source, filepath.FromSlash(name)))
+		if err != nil {
+			return err
+		}
+		file.Path = path.Join(bundleChartsFolder, name)
+		manifest.Files = append(manifest.Files, file)
+	}
+
+	if err := e.write(manifest, images); err != nil {
+		return err
+	}
+
+	if e.verbose {
+		e.logger.Printf("Exported %d files from %q to %q", len(names), e.source, e.bundle)
+	}
+
+	return nil
+}
+
+// images returns the sorted list of the container images used by the charts
+// among names, one per line.
+func (e *ExportService) images(names []string) ([]byte, error) {
+	list := &imageList{images: map[string]bool{}}
+	for _, name := range names {
+		if !strings.HasSuffix(name, ".tgz") {
+			continue
+		}
+
+		chartPath := filepath.Join(e.source, filepath.FromSlash(name))
+		if err := NewImagesService(chartPath, e.verbose, e.ignoreErrors, list, e.logger).Images(); err != nil {
+			if !e.ignoreErrors {
+				return nil, fmt.Errorf("cannot list the images of chart %q: %w", name, err)
+			}
+			e.logger.Printf("WARNING: listing the images of chart %q - %s", name, err)
+		}
+	}
+
+	images := make([]string, 0, len(list.images))
+	for image := range list.images {
+		images = append(images, image+"\n")
+	}
+	sort.Strings(images)
+
+	return []byte(strings.Join(images, "")), nil
+}
+
+// write writes the manifest, the image list and the files of the mirror to
+// the bundle file, checking the files did not change since they were listed.
+// The bundle is written to a temporary file next to it and renamed once
+// complete, so a failed export leaves any previous bundle untouched.
+func (e *ExportService) write(manifest *BundleManifest, images []byte) error {
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("cannot encode bundle manifest: %w", err)
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(e.bundle), "."+filepath.Base(e.bundle)+"-")
+	if err != nil {
+		return fmt.Errorf("cannot create bundle: %w", err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	gz := gzip.NewWriter(file)
+	archive := tar.NewWriter(gz)
+	if err := writeTarFile(archive, bundleManifestName, manifest.Created, bytes.NewReader(content), int64(len(content))); err != nil {
+		return err
+	}
+	if err := writeTarFile(archive, bundleImagesName, manifest.Created, bytes.NewReader(images), int64(len(images))); err != nil {
+		return err
+	}
+
+	for _, bundled := range manifest.Files[1:] {
+		name := filepath.Join(e.source, filepath.FromSlash(strings.TrimPrefix(bundled.Path, bundleChartsFolder+"/")))
+		if err := copyToTar(archive, bundled, name, manifest.Created); err != nil {
+			return err
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return fmt.Errorf("cannot write bundle: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("cannot write bundle: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("cannot write bundle: %w", err)
+	}
+	if err := os.Chmod(file.Name(), 0o644); err != nil {
+		return fmt.Errorf("cannot write bundle: %w", err)
+	}
+	if err := os.Rename(file.Name(), e.bundle); err != nil {
+		return fmt.Errorf("cannot write bundle: %w", err)
+	}
+
+	return nil
+}
+
+// copyToTar adds the file at name to archive as bundled, failing when its
+// content no longer matches the digest of bundled.
+func copyToTar(archive *tar.Writer, bundled BundleFile, name string, modTime time.Time) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return fmt.Errorf("cannot open %q: %w", name, err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if err := writeTarFile(archive, bundled.Path, modTime, io.TeeReader(file, hash), bundled.Size); err != nil {
+		return err
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != bundled.Digest {
+		return fmt.Errorf("%q changed while being exported", name)
+	}
+
+	return nil
+}
+
+// writeTarFile adds a regular file of the given size, read from r, to
+// archive.
+func writeTarFile(archive *tar.Writer, name string, modTime time.Time, r io.Reader, size int64) error {
+	header := &tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0o644, Size: size, ModTime: modTime}
+	if err := archive.WriteHeader(header); err != nil {
+		return fmt.Errorf("cannot write %q to bundle: %w", name, err)
+	}
+	if _, err := io.Copy(archive, r); err != nil {
+		return fmt.Errorf("cannot write %q to bundle: %w", name, err)
+	}
+
+	return nil
+}
+
+// mirrorFiles returns the paths, relative to folder and slash separated, of
+// the charts, provenance files and index files of the mirror in folder.
+func mirrorFiles(folder string) ([]string, error) {
+	var names []string
+	err := filepath.WalkDir(folder, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		base := entry.Name()
+		if base != indexFileName && !strings.HasSuffix(base, ".tgz") && !strings.HasSuffix(base, ".tgz"+provenanceExt) {
+			return nil
+		}
+
+		relative, err := filepath.Rel(folder, name)
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.ToSlash(relative))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot list the files of %q: %w", folder, err)
+	}
+
+	return names, nil
+}
+
+// fileDigest returns the size and SHA-256 digest of the file at name.
+func fileDigest(name string) (BundleFile, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return BundleFile{}, fmt.Errorf("cannot open %q: %w", name, err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return BundleFile{}, fmt.Errorf("cannot read %q: %w", name, err)
+	}
+
+	return BundleFile{Size: size, Digest: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+// bundleFile describes content as the file of the bundle at name.
+func bundleFile(name string, content []byte) BundleFile {
+	sum := sha256.Sum256(content)
+	return BundleFile{Path: name, Size: int64(len(content)), Digest: hex.EncodeToString(sum[:])}
+}
+
+// imageList collects the images listed by ImagesService, without duplicates
+type imageList struct {
+	images map[string]bool
+}
+
+// Output adds the images of buffer, one per line, to the list.
+func (l *imageList) Output(buffer bytes.Buffer) error {
+	scanner := bufio.NewScanner(&buffer)
+	for scanner.Scan() {
+		if image := strings.TrimSpace(scanner.Text()); image != "" {
+			l.images[image] = true
+		}
+	}
+
+	return scanner.Err() //nolint:wrapcheck
+}
+
+// ImportService verifies and unpacks a bundle written by ExportService
+type ImportService struct {
+	bundle       string
+	destination  string
+	publishers   []Publisher
+	verbose      bool
+	ignoreErrors bool
+	logger       *log.Logger
+}
+
+// NewImportService return a new instance of ImportService unpacking the
+// bundle file at bundle into destination, when it is not empty, and
+// publishing its charts to publishers
+func NewImportService(bundle string, destination string, publishers []Publisher, verbose bool, ignoreErrors bool, logger *log.Logger) *ImportService {
+	return &ImportService{
+		bundle:       bundle,
+		destination:  destination,
+		publishers:   publishers,
+		verbose:      verbose,
+		ignoreErrors: ignoreErrors,
+		logger:       logger,
+	}
+}
+
+// Import unpacks the bundle into a staging folder and checks every file
+// against the manifest of the bundle before moving the mirror and the image
+// list into the destination folder and publishing the charts. Nothing is
+// written to the destination folder or published when the bundle is
+// incomplete or corrupted. The index files are moved last, the top one at the
+// very end, so that if moving a file fails the index files in place only list
+// charts that are there.
+func (i *ImportService) Import() error {
+	stagingParent := ""
+	if i.destination != "" {
+		if err := os.MkdirAll(i.destination, 0o744); err != nil {
+			return fmt.Errorf("cannot create destination folder: %w", err)
+		}
+		stagingParent = i.destination
+	}
+
+	staging, err := os.MkdirTemp(stagingParent, ".helm-mirror-import-")
+	if err != nil {
+		return fmt.Errorf("cannot create staging folder: %w", err)
+	}
+	defer os.RemoveAll(staging)
+
+	manifest, err := i.unpack(staging)
+	if err != nil {
+		return err
+	}
+
+	charts := filepath.Join(staging, bundleChartsFolder)
+	if i.destination != "" {
+		for _, file := range importOrder(manifest.Files) {
+			name := strings.TrimPrefix(file.Path, bundleChartsFolder+"/")
+			target := filepath.Join(i.destination, filepath.FromSlash(name))
+			if err := os.MkdirAll(filepath.Dir(target), 0o744); err != nil {
+				return fmt.Errorf("cannot create folder for %q: %w", name, err)
+			}
+			if err := os.Rename(filepath.Join(staging, filepath.FromSlash(file.Path)), target); err != nil {
+				return fmt.Errorf("cannot move %q to the destination folder: %w", name, err)
+			}
+		}
+		charts = i.destination
+	}
+
+	if i.verbose {
+		i.logger.Printf("Imported %d files from %q", len(manifest.Files), i.bundle)
+	}
+
+	return i.publish(manifest, charts)
+}
+
+// importOrder returns files in the order they are moved to the destination
+// folder: the charts, provenance files and image list first, then the index
+// files, deepest first.
+func importOrder(files []BundleFile) []BundleFile {
+	ordered := slices.Clone(files)
+	rank := func(file BundleFile) int {
+		if path.Base(file.Path) != indexFileName {
+			return math.MinInt
+		}
+		return -strings.Count(file.Path, "/")
+	}
+	slices.SortStableFunc(ordered, func(a, b BundleFile) int {
+		return cmp.Compare(rank(a), rank(b))
+	})
+
+	return ordered
+}
+
+// unpack writes the files of the bundle to staging, checking them against
+// the manifest of the bundle, and returns the manifest.
+func (i *ImportService) unpack(staging string) (*BundleManifest, error) {
+	file, err := os.Open(i.bundle)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open bundle: %w", err)
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read bundle %q: %w", i.bundle, err)
+	}
+	defer gz.Close()
+
+	archive := tar.NewReader(gz)
+	manifest, err := readBundleManifest(archive)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bundle %q: %w", i.bundle, err)
+	}
+
+	expected := map[string]BundleFile{}
+	for _, bundled := range manifest.Files {
+		if !validBundlePath(bundled.Path) {
+			return nil, fmt.Errorf("invalid bundle %q: unexpected file %q in manifest", i.bundle, bundled.Path)
+		}
+		expected[bundled.Path] = bundled
+	}
+
+	for {
+		header, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot read bundle %q: %w", i.bundle, err)
+		}
+
+		bundled, ok := expected[header.Name]
+		if !ok || header.Typeflag != tar.TypeReg {
+			return nil, fmt.Errorf("invalid bundle %q: %q is not listed in its manifest", i.bundle, header.Name)
+		}
+		delete(expected, header.Name)
+
+		if err := extractFile(archive, bundled, filepath.Join(staging, filepath.FromSlash(bundled.Path))); err != nil {
+			return nil, fmt.Errorf("invalid bundle %q: %w", i.bundle, err)
+		}
+	}
+
+	if len(expected) > 0 {
+		missing := make([]string, 0, len(expected))
+		for name := range expected {
+			missing = append(missing, name)
+		}
+		sort.Strings(missing)
+		return nil, fmt.Errorf("invalid bundle %q: missing %s", i.bundle, strings.Join(missing, ", "))
+	}
+
+	return manifest, nil
+}
+
+// publish hands the charts of the bundle, unpacked in the charts folder, to
+// every publisher, like the mirror command does with the mirrored charts.
+func (i *ImportService) publish(manifest *BundleManifest, charts string) error {
+	if len(i.publishers) == 0 {
+		return nil
+	}
+
+	var errs []error
+	for _, file := range manifest.Files {
+		if !strings.HasSuffix(file.Path, ".tgz") {
+			continue
+		}
+
+		chartPath := filepath.Join(charts, filepath.FromSlash(strings.TrimPrefix(file.Path, bundleChartsFolder+"/")))
+		chart, err := chartutil.Load(chartPath)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("cannot load chart %q: %w", file.Path, err))
+			continue
+		}
+		name, version := chart.GetMetadata().GetName(), chart.GetMetadata().GetVersion()
+
+		for _, publisher := range i.publishers {
+			published, err := publisher.Publish(chartPath, name, version)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("cannot publish chart %s(%s) to %s: %w", name, version, publisher, err))
+				continue
+			}
+			switch {
+			case !i.verbose:
+			case published:
+				i.logger.Printf("Published chart %q (version %s) to %s", name, version, publisher)
+			default:
+				i.logger.Printf("Skipping chart %q (version %s): already published to %s", name, version, publisher)
+			}
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	err := fmt.Errorf("cannot publish charts: %w", errors.Join(errs...))
+	if i.ignoreErrors {
+		i.logger.Printf("WARNING: %s", err)
+		return nil
+	}
+
+	return err
+}
+
+// readBundleManifest reads the manifest of the bundle, which must be its
+// first file, and checks its version is supported.
+func readBundleManifest(archive *tar.Reader) (*BundleManifest, error) {
+	header, err := archive.Next()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read manifest: %w", err)
+	}
+	if header.Name != bundleManifestName {
+		return nil, fmt.Errorf("manifest %s not found", bundleManifestName)
+	}
+
+	content, err := io.ReadAll(io.LimitReader(archive, maxBundleManifest))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read manifest: %w", err)
+	}
+
+	manifest := &BundleManifest{}
+	if err := json.Unmarshal(content, manifest); err != nil {
+		return nil, fmt.Errorf("cannot parse manifest: %w", err)
+	}
+	if manifest.Version < 1 || manifest.Version > BundleVersion {
+		return nil, fmt.Errorf("bundle format version %d is not supported, only up to version %d is", manifest.Version, BundleVersion)
+	}
+
+	return manifest, nil
+}
+
+// validBundlePath reports whether name is the image list or a file under the
+// charts folder of a bundle, without escaping it.
+func validBundlePath(name string) bool {
+	if name == bundleImagesName {
+		return true
+	}
+
+	relative, ok := strings.CutPrefix(name, bundleChartsFolder+"/")
+	return ok && path.Clean(relative) == relative && relative != "." && !strings.HasPrefix(relative, "../") && relative != ".."
+}
+
+// extractFile writes the file read from archive to name, failing when its
+// size or digest does not match bundled.
+func extractFile(archive io.Reader, bundled BundleFile, name string) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0o744); err != nil {
+		return fmt.Errorf("cannot create folder for %q: %w", bundled.Path, err)
+	}
+
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("cannot create %q: %w", bundled.Path, err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(file, hash), io.LimitReader(archive, bundled.Size+1))
+	if err != nil {
+		return fmt.Errorf("cannot extract %q: %w", bundled.Path, err)
+	}
+	if size != bundled.Size || hex.EncodeToString(hash.Sum(nil)) != bundled.Digest {
+		return fmt.Errorf("checksum mismatch for %q", bundled.Path)
+	}
+
+	return file.Close() //nolint:wrapcheck
+}
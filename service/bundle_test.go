@@ -0,0 +1,293 @@
+package service
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newMirror writes a mirror with a chart, its provenance file and a
+// dependency in a subfolder to folder.
+func newMirror(t *testing.T, folder string) {
+	t.Helper()
+
+	files := map[string][]byte{
+		"index.yaml":            []byte("apiVersion: v1\nentries: {}\n"),
+		"app-1.0.0.tgz":         chartArchive(t, map[string]string{"app/Chart.yaml": "name: app\nversion: 1.0.0\n", "app/templates/pod.yaml": "image: nginx:1.25\n"}),
+		"app-1.0.0.tgz.prov":    []byte("signature"),
+		"downloaded-index.yaml": []byte("skipped"),
+		"dependencies/charts.example.com/index.yaml":   []byte("apiVersion: v1\nentries: {}\n"),
+		"dependencies/charts.example.com/db-2.0.0.tgz": chartArchive(t, map[string]string{"db/Chart.yaml": "name: db\nversion: 2.0.0\n", "db/templates/pod.yaml": "image: \"postgres:16\"\n"}),
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(path.Dir(path.Join(folder, name)), 0o755); err != nil {
+			t.Fatalf("creating mirror folder: %s", err)
+		}
+		if err := os.WriteFile(path.Join(folder, name), content, 0o600); err != nil {
+			t.Fatalf("writing mirror: %s", err)
+		}
+	}
+}
+
+// rewriteBundle copies the bundle at source to target, changing the files
+// of the bundle with change.
+func rewriteBundle(t *testing.T, source string, target string, change func(name string, content []byte) []byte) {
+	t.Helper()
+
+	in, err := os.Open(source)
+	if err != nil {
+		t.Fatalf("opening bundle: %s", err)
+	}
+	defer in.Close()
+	gzIn, err := gzip.NewReader(in)
+	if err != nil {
+		t.Fatalf("reading bundle: %s", err)
+	}
+	out, err := os.Create(target)
+	if err != nil {
+		t.Fatalf("creating bundle: %s", err)
+	}
+	defer out.Close()
+	gzOut := gzip.NewWriter(out)
+	archiveOut := tar.NewWriter(gzOut)
+
+	archiveIn := tar.NewReader(gzIn)
+	for {
+		header, err := archiveIn.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("reading bundle: %s", err)
+		}
+		content, _ := io.ReadAll(archiveIn)
+		content = change(header.Name, content)
+		header.Size = int64(len(content))
+		archiveOut.WriteHeader(header)
+		archiveOut.Write(content)
+	}
+	archiveOut.Close()
+	gzOut.Close()
+}
+
+func TestExportService_Export(t *testing.T) {
+	dir, err := os.MkdirTemp("", "helmmirrortests")
+	if err != nil {
+		t.Errorf("Creating tmp directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	mirror := path.Join(dir, "mirror")
+	newMirror(t, mirror)
+	bundle := path.Join(dir, "mirror.tar.gz")
+
+	if err := NewExportService(path.Join(dir, "empty"), bundle, false, false, fakeLogger).Export(); err == nil {
+		t.Errorf("ExportService.Export() error = nil, want an error for a missing folder")
+	}
+	if err := NewExportService(mirror, bundle, false, false, fakeLogger).Export(); err != nil {
+		t.Fatalf("ExportService.Export() error = %v", err)
+	}
+
+	files := map[string]string{}
+	var names []string
+	rewriteBundle(t, bundle, path.Join(dir, "copy.tar.gz"), func(name string, content []byte) []byte {
+		names = append(names, name)
+		files[name] = string(content)
+		return content
+	})
+
+	wantNames := []string{
+		"bundle.json",
+		"images.txt",
+		"charts/app-1.0.0.tgz",
+		"charts/app-1.0.0.tgz.prov",
+		"charts/dependencies/charts.example.com/db-2.0.0.tgz",
+		"charts/dependencies/charts.example.com/index.yaml",
+		"charts/index.yaml",
+	}
+	if !reflect.DeepEqual(names, wantNames) {
+		t.Errorf("ExportService.Export() files = %v, want %v", names, wantNames)
+	}
+	if got, want := files["images.txt"], "nginx:1.25\npostgres:16\n"; got != want {
+		t.Errorf("ExportService.Export() images = %q, want %q", got, want)
+	}
+
+	manifest := &BundleManifest{}
+	if err := json.Unmarshal([]byte(files["bundle.json"]), manifest); err != nil {
+		t.Fatalf("parsing bundle manifest: %s", err)
+	}
+	if manifest.Version != BundleVersion || len(manifest.Files) != len(wantNames)-1 {
+		t.Errorf("ExportService.Export() manifest = %+v", manifest)
+	}
+	for _, file := range manifest.Files {
+		if want := bundleFile(file.Path, []byte(files[file.Path])); file != want {
+			t.Errorf("ExportService.Export() manifest entry = %+v, want %+v", file, want)
+		}
+	}
+
+	blocked := path.Join(dir, "blocked.tar.gz")
+	if err := os.MkdirAll(path.Join(blocked, "keep"), 0o755); err != nil {
+		t.Fatalf("creating folder: %s", err)
+	}
+	if err := NewExportService(mirror, blocked, false, false, fakeLogger).Export(); err == nil {
+		t.Errorf("ExportService.Export() error = nil, want an error when the bundle cannot be moved into place")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("listing folder: %s", err)
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			t.Errorf("ExportService.Export() left temporary file %q", entry.Name())
+		}
+	}
+}
+
+func Test_importOrder(t *testing.T) {
+	files := []BundleFile{
+		{Path: "images.txt"},
+		{Path: "charts/app-1.0.0.tgz"},
+		{Path: "charts/dependencies/charts.example.com/index.yaml"},
+		{Path: "charts/index.yaml"},
+		{Path: "charts/dependencies/charts.example.com/db-2.0.0.tgz"},
+		{Path: "charts/dependencies/charts.example.com/nested/index.yaml"},
+	}
+	want := []string{
+		"images.txt",
+		"charts/app-1.0.0.tgz",
+		"charts/dependencies/charts.example.com/db-2.0.0.tgz",
+		"charts/dependencies/charts.example.com/nested/index.yaml",
+		"charts/dependencies/charts.example.com/index.yaml",
+		"charts/index.yaml",
+	}
+
+	var got []string
+	for _, file := range importOrder(files) {
+		got = append(got, file.Path)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("importOrder() = %v, want %v", got, want)
+	}
+}
+
+func TestImportService_Import(t *testing.T) {
+	dir, err := os.MkdirTemp("", "helmmirrortests")
+	if err != nil {
+		t.Errorf("Creating tmp directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	mirror := path.Join(dir, "mirror")
+	newMirror(t, mirror)
+	bundle := path.Join(dir, "mirror.tar.gz")
+	if err := NewExportService(mirror, bundle, false, false, fakeLogger).Export(); err != nil {
+		t.Fatalf("ExportService.Export() error = %v", err)
+	}
+
+	corrupted := path.Join(dir, "corrupted.tar.gz")
+	rewriteBundle(t, bundle, corrupted, func(name string, content []byte) []byte {
+		if name == "charts/app-1.0.0.tgz.prov" {
+			return []byte("tampered")
+		}
+		return content
+	})
+	newer := path.Join(dir, "newer.tar.gz")
+	rewriteBundle(t, bundle, newer, func(name string, content []byte) []byte {
+		if name == bundleManifestName {
+			return []byte(strings.Replace(string(content), `"version": 1`, `"version": 2`, 1))
+		}
+		return content
+	})
+	escaping := path.Join(dir, "escaping.tar.gz")
+	rewriteBundle(t, bundle, escaping, func(name string, content []byte) []byte {
+		if name == bundleManifestName {
+			return []byte(strings.Replace(string(content), `"charts/index.yaml"`, `"charts/../index.yaml"`, 1))
+		}
+		return content
+	})
+
+	tests := []struct {
+		name          string
+		bundle        string
+		destination   string
+		wantErr       bool
+		wantPublished []string
+	}{
+		{"1", bundle, "imported", false, []string{"app-1.0.0", "db-2.0.0"}},
+		{"2", bundle, "", false, []string{"app-1.0.0", "db-2.0.0"}},
+		{"3", corrupted, "corrupted", true, nil},
+		{"4", newer, "newer", true, nil},
+		{"5", escaping, "escaping", true, nil},
+		{"6", path.Join(dir, "missing.tar.gz"), "missing", true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			destination := ""
+			if tt.destination != "" {
+				destination = path.Join(dir, tt.destination)
+			}
+			publisher := &mockPublisher{}
+			err := NewImportService(tt.bundle, destination, []Publisher{publisher}, false, false, fakeLogger).Import()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ImportService.Import() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(publisher.published, tt.wantPublished) {
+				t.Errorf("ImportService.Import() published = %v, want %v", publisher.published, tt.wantPublished)
+			}
+			if destination == "" {
+				return
+			}
+
+			got, err := mirrorFiles(destination)
+			if err != nil && !tt.wantErr {
+				t.Errorf("listing imported files: %s", err)
+			}
+			var want []string
+			if !tt.wantErr {
+				want = []string{"app-1.0.0.tgz", "app-1.0.0.tgz.prov", "dependencies/charts.example.com/db-2.0.0.tgz", "dependencies/charts.example.com/index.yaml", "index.yaml"}
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("ImportService.Import() files = %v, want %v", got, want)
+			}
+			if _, err := os.Stat(path.Join(destination, bundleImagesName)); (err == nil) == tt.wantErr {
+				t.Errorf("ImportService.Import() image list written = %v, want %v", err == nil, !tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestImportService_ImportMoveFailure(t *testing.T) {
+	dir := t.TempDir()
+	mirror := path.Join(dir, "mirror")
+	newMirror(t, mirror)
+	// listed after index.yaml in the bundle
+	web := chartArchive(t, map[string]string{"web/Chart.yaml": "name: web\nversion: 1.0.0\n"})
+	if err := os.WriteFile(path.Join(mirror, "web-1.0.0.tgz"), web, 0o600); err != nil {
+		t.Fatalf("writing mirror: %s", err)
+	}
+	bundle := path.Join(dir, "mirror.tar.gz")
+	if err := NewExportService(mirror, bundle, false, false, fakeLogger).Export(); err != nil {
+		t.Fatalf("ExportService.Export() error = %v", err)
+	}
+
+	destination := path.Join(dir, "destination")
+	if err := os.MkdirAll(path.Join(destination, "web-1.0.0.tgz", "keep"), 0o755); err != nil {
+		t.Fatalf("creating folder: %s", err)
+	}
+	if err := os.WriteFile(path.Join(destination, "index.yaml"), []byte("previous"), 0o600); err != nil {
+		t.Fatalf("writing index: %s", err)
+	}
+
+	if err := NewImportService(bundle, destination, nil, false, false, fakeLogger).Import(); err == nil {
+		t.Errorf("ImportService.Import() error = nil, want an error when a file cannot be moved into place")
+	}
+	if content, err := os.ReadFile(path.Join(destination, "index.yaml")); err != nil || string(content) != "previous" {
+		t.Errorf("ImportService.Import() index = %q, %v, want the previous index to be kept", content, err)
+	}
+}
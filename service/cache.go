@@ -0,0 +1,57 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"k8s.io/helm/pkg/repo"
+)
+
+// WithCachedIndex uses the index file at cachePath, such as the one Helm
+// caches for a repository, instead of downloading the index file when it was
+// written less than maxAge ago.
+func WithCachedIndex(cachePath string, maxAge time.Duration) GetOption {
+	return func(g *GetService) {
+		g.cachedIndex = cachePath
+		g.cacheMaxAge = maxAge
+	}
+}
+
+// freshCachedIndex reports whether the cached index file exists and is recent
+// enough to be used.
+func (g *GetService) freshCachedIndex() bool {
+	if g.cachedIndex == "" || g.cacheMaxAge <= 0 {
+		return false
+	}
+
+	info, err := os.Stat(g.cachedIndex)
+	if err != nil {
+		return false
+	}
+
+	return time.Since(info.ModTime()) < g.cacheMaxAge
+}
+
+// fetchIndexFile writes the index file of the repository to name, copying
+// the cached index file when it is fresh and downloading it otherwise.
+func (g *GetService) fetchIndexFile(chartRepo *repo.ChartRepository, name string) error {
+	if g.freshCachedIndex() {
+		g.logVerbose("Using cached index file %q", g.cachedIndex)
+		content, err := os.ReadFile(g.cachedIndex)
+		if err == nil {
+			if err := os.WriteFile(name, content, 0o600); err != nil {
+				return fmt.Errorf("cannot copy cached index file: %w", err)
+			}
+			return nil
+		}
+		g.logVerbose("Cannot read cached index file %q, downloading it: %s", g.cachedIndex, err)
+	}
+
+	g.logVerbose("Downloading index file from %s", g.config.URL)
+	if err := chartRepo.DownloadIndexFile(name); err != nil {
+		return fmt.Errorf("cannot download index file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package service
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/konstructio/helm-mirror/fixtures"
+	"k8s.io/helm/pkg/repo"
+)
+
+func TestGetService_GetCachedIndex(t *testing.T) {
+	svr := fixtures.NewRepositoryServer()
+	defer svr.Close()
+
+	tests := []struct {
+		name    string
+		age     time.Duration
+		maxAge  time.Duration
+		wantErr bool
+	}{
+		{"1", time.Minute, time.Hour, false},
+		{"2", 2 * time.Hour, time.Hour, true},
+		{"3", time.Minute, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := os.MkdirTemp("", "helmmirrortests")
+			if err != nil {
+				t.Errorf("Creating tmp directory: %s", err)
+			}
+			defer os.RemoveAll(dir)
+
+			// the repository URL has no index file: only the cached one can be used
+			cachePath := path.Join(dir, "stable-index.yaml")
+			if err := os.WriteFile(cachePath, []byte(strings.ReplaceAll(fixtures.IndexYaml, "http://127.0.0.1:1793", svr.URL)), 0o600); err != nil {
+				t.Errorf("writing cached index: %s", err)
+			}
+			modTime := time.Now().Add(-tt.age)
+			if err := os.Chtimes(cachePath, modTime, modTime); err != nil {
+				t.Errorf("setting cached index age: %s", err)
+			}
+
+			folder := path.Join(dir, "mirror")
+			if err := os.Mkdir(folder, 0o755); err != nil {
+				t.Errorf("creating mirror folder: %s", err)
+			}
+			g := NewGetService(repo.Entry{Name: folder, URL: svr.URL + "/missing"}, false, false, false, fakeLogger, "", "chart2", "", WithCachedIndex(cachePath, tt.maxAge))
+			if err := g.Get(); (err != nil) != tt.wantErr {
+				t.Errorf("GetService.Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if _, err := os.Stat(path.Join(folder, "chart2-1.0.1.tgz")); (err != nil) != tt.wantErr {
+				t.Errorf("GetService.Get() chart downloaded = %v, want %v", err == nil, !tt.wantErr)
+			}
+		})
+	}
+}
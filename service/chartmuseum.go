@@ -0,0 +1,119 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+
+	"k8s.io/helm/pkg/repo"
+)
+
+// ChartMuseumPublisher uploads charts to a ChartMuseum-compatible
+// /api/charts endpoint
+type ChartMuseumPublisher struct {
+	baseURL  *url.URL
+	client   *http.Client
+	username string
+	password string
+	token    string
+	force    bool
+}
+
+// NewChartMuseumPublisher returns a publisher uploading charts to the
+// ChartMuseum server at config.URL, using the credentials and TLS files in
+// config. When token is set, it is sent as a bearer token instead of the
+// username and password. When force is set, charts that already exist on the
+// server are overwritten; otherwise they are skipped.
+func NewChartMuseumPublisher(config repo.Entry, token string, force bool) (*ChartMuseumPublisher, error) {
+	baseURL, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ChartMuseum URL %q: %w", config.URL, err)
+	}
+
+	transport, err := newTransport(config, config.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ChartMuseumPublisher{
+		baseURL:  baseURL,
+		client:   &http.Client{Transport: transport},
+		username: config.Username,
+		password: config.Password,
+		token:    token,
+		force:    force,
+	}, nil
+}
+
+func (c *ChartMuseumPublisher) String() string {
+	return c.baseURL.String()
+}
+
+// Publish uploads the chart at chartPath, and its provenance file when one
+// sits next to it. Charts rejected because they already exist are reported as
+// not published.
+func (c *ChartMuseumPublisher) Publish(chartPath string, _ string, _ string) (bool, error) {
+	published, err := c.upload("charts", chartPath)
+	if err != nil || !published {
+		return published, err
+	}
+
+	provPath := chartPath + provenanceExt
+	if !fileExists(provPath) {
+		return true, nil
+	}
+
+	if _, err := c.upload("prov", provPath); err != nil {
+		return true, err
+	}
+
+	return true, nil
+}
+
+// upload posts the file at name to the given /api endpoint.
+func (c *ChartMuseumPublisher) upload(endpoint string, name string) (bool, error) {
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return false, fmt.Errorf("cannot read %q: %w", name, err)
+	}
+
+	target := *c.baseURL
+	target.Path = path.Join(target.Path, "api", endpoint)
+	if c.force {
+		target.RawQuery = "force=true"
+	}
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, target.String(), bytes.NewReader(content))
+	if err != nil {
+		return false, fmt.Errorf("cannot create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	switch {
+	case c.token != "":
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	case c.username != "" && c.password != "":
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("cannot reach %s: %w", target.Host, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusConflict && !c.force:
+		return false, nil
+	case resp.StatusCode >= 200 && resp.StatusCode <= 299:
+		return true, nil
+	default:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return false, fmt.Errorf("cannot upload %q: %s: %s", path.Base(name), resp.Status, bytes.TrimSpace(body))
+	}
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync"
+	"testing"
+
+	"k8s.io/helm/pkg/repo"
+)
+
+type chartMuseumStandIn struct {
+	mu     sync.Mutex
+	charts map[string]int
+	provs  int
+}
+
+func (c *chartMuseumStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user, pass, ok := r.BasicAuth()
+	if r.Header.Get("Authorization") != "Bearer token" && (!ok || user != "user" || pass != "secret") {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	content, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch r.URL.Path {
+	case "/museum/api/charts":
+		if c.charts[string(content)] > 0 && r.URL.Query().Get("force") != "true" {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		c.charts[string(content)]++
+		w.WriteHeader(http.StatusCreated)
+	case "/museum/api/prov":
+		c.provs++
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestChartMuseumPublisher_Publish(t *testing.T) {
+	dir, err := os.MkdirTemp("", "helmmirrortests")
+	if err != nil {
+		t.Errorf("Creating tmp directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	chartPath := path.Join(dir, "chart-1.0.0.tgz")
+	signedPath := path.Join(dir, "signed-1.0.0.tgz")
+	os.WriteFile(chartPath, []byte("chart"), 0o600)
+	os.WriteFile(signedPath, []byte("signed"), 0o600)
+	os.WriteFile(signedPath+".prov", []byte("prov"), 0o600)
+	museum := &chartMuseumStandIn{charts: map[string]int{}}
+	svr := httptest.NewServer(museum)
+	defer svr.Close()
+	tests := []struct {
+		name      string
+		url       string
+		username  string
+		password  string
+		token     string
+		force     bool
+		chartPath string
+		want      bool
+		wantErr   bool
+		wantProvs int
+	}{
+		{"1", svr.URL + "/museum", "user", "secret", "", false, chartPath, true, false, 0},
+		{"2", svr.URL + "/museum", "user", "secret", "", false, chartPath, false, false, 0},
+		{"3", svr.URL + "/museum/", "", "", "token", true, chartPath, true, false, 0},
+		{"4", svr.URL + "/museum", "user", "wrong", "", false, signedPath, false, true, 0},
+		{"5", svr.URL + "/museum", "", "", "token", false, signedPath, true, false, 1},
+		{"6", svr.URL + "/museum", "user", "secret", "", false, path.Join(dir, "missing.tgz"), false, true, 1},
+		{"7", svr.URL + "/other", "user", "secret", "", false, chartPath, false, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			publisher, err := NewChartMuseumPublisher(repo.Entry{URL: tt.url, Username: tt.username, Password: tt.password}, tt.token, tt.force)
+			if err != nil {
+				t.Errorf("NewChartMuseumPublisher() error = %v", err)
+				return
+			}
+			got, err := publisher.Publish(tt.chartPath, "chart", "1.0.0")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ChartMuseumPublisher.Publish() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ChartMuseumPublisher.Publish() = %v, want %v", got, tt.want)
+			}
+			if museum.provs != tt.wantProvs {
+				t.Errorf("ChartMuseumPublisher.Publish() provenance uploads = %v, want %v", museum.provs, tt.wantProvs)
+			}
+		})
+	}
+}
@@ -0,0 +1,368 @@
+package service
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver"
+	"github.com/ghodss/yaml"
+	"k8s.io/helm/pkg/repo"
+	"k8s.io/helm/pkg/urlutil"
+)
+
+// dependenciesFolder is the subfolder of the destination folder holding a
+// folder per repository dependencies are mirrored from
+const dependenciesFolder = "dependencies"
+
+// maxDependencyFileSize bounds the size of the chart files dependencies are
+// read from
+const maxDependencyFileSize = 1 << 20
+
+// dependencyFiles lists the chart files declaring dependencies, by order of
+// preference: the lock files pin the versions the ranges were resolved to.
+var dependencyFiles = []string{"Chart.lock", "requirements.lock", "Chart.yaml", "requirements.yaml"} //nolint:gochecknoglobals
+
+// WithDependencies also mirrors the charts the mirrored charts depend on,
+// as declared in their Chart.yaml or requirements.yaml, transitively. Each
+// dependency is mirrored from its own repository into a subfolder of the
+// destination folder named after the repository URL, at the version locked by
+// the chart or the newest version satisfying its range.
+func WithDependencies() GetOption {
+	return func(g *GetService) {
+		g.dependencies = true
+	}
+}
+
+// chartDependency is a dependency declared by a chart
+type chartDependency struct {
+	Name       string `json:"name"`
+	Version    string `json:"version"`
+	Repository string `json:"repository"`
+}
+
+// dependencyState is shared by the services mirroring the dependencies of a
+// repository, transitively: root is the service mirroring the repository, and
+// downloads the charts the other services selected.
+type dependencyState struct {
+	root      *GetService
+	seen      map[string]bool
+	downloads []*chartDownload
+}
+
+// mirrorDependencies mirrors the dependencies of the mirrored charts, one
+// repository after the other. Dependencies on local charts are vendored in
+// the archives and skipped, as are the ones on repositories given by a Helm
+// repository name rather than a URL.
+func (g *GetService) mirrorDependencies(downloads []*chartDownload) error {
+	if !g.dependencies || g.dryRun {
+		return nil
+	}
+
+	if g.dependencyState == nil {
+		g.dependencyState = &dependencyState{root: g, seen: map[string]bool{}}
+	}
+
+	var repoURLs []string
+	ranges := map[string]map[string][]*semver.Constraints{}
+	for _, download := range downloads {
+		if !download.mirrored() {
+			continue
+		}
+
+		dependencies, err := chartDependencies(download.path)
+		if err != nil {
+			g.logger.Printf("WARNING: reading dependencies of chart %s(%s) - %s", download.name, download.version, err)
+			continue
+		}
+
+		for _, dependency := range dependencies {
+			repoURL, ok := dependencyRepository(dependency.Repository)
+			if !ok {
+				if dependency.Repository != "" && !strings.HasPrefix(dependency.Repository, "file://") {
+					g.logger.Printf("WARNING: cannot mirror dependency %s of chart %s(%s) from %q, only repository URLs are supported", dependency.Name, download.name, download.version, dependency.Repository)
+				}
+				continue
+			}
+
+			key := strings.Join([]string{repoURL, dependency.Name, dependency.Version}, " ")
+			if g.dependencyState.seen[key] {
+				continue
+			}
+			g.dependencyState.seen[key] = true
+
+			var constraint *semver.Constraints
+			if dependency.Version != "" {
+				if constraint, err = parseVersionConstraint(dependency.Version); err != nil {
+					g.logger.Printf("WARNING: dependency %s of chart %s(%s) - %s", dependency.Name, download.name, download.version, err)
+					continue
+				}
+			}
+
+			g.logVerbose("Found dependency %s (%s) of chart %s(%s) in %s", dependency.Name, dependency.Version, download.name, download.version, repoURL)
+			if _, ok := ranges[repoURL]; !ok {
+				repoURLs = append(repoURLs, repoURL)
+				ranges[repoURL] = map[string][]*semver.Constraints{}
+			}
+			ranges[repoURL][dependency.Name] = append(ranges[repoURL][dependency.Name], constraint)
+		}
+	}
+
+	var errs []error
+	for _, repoURL := range repoURLs {
+		dependencyService, err := g.dependencyService(repoURL, ranges[repoURL])
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		g.logVerbose("Mirroring %d dependencies from %s into %q", len(ranges[repoURL]), repoURL, dependencyService.config.Name)
+		if err := os.MkdirAll(dependencyService.config.Name, 0o744); err != nil {
+			errs = append(errs, fmt.Errorf("cannot create dependency folder: %w", err))
+			continue
+		}
+
+		err = dependencyService.get()
+		g.attempted = append(g.attempted, dependencyService.attempted...)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("repository %s: %w", repoURL, err))
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	err := fmt.Errorf("cannot mirror dependencies: %w", errors.Join(errs...))
+	if g.ignoreErrors {
+		g.logger.Printf("WARNING: %s", err)
+		return nil
+	}
+
+	return err
+}
+
+// dependencyDownloads returns the dependencies selected into folder, such as
+// the ones living in the mirrored repository, so pruning keeps them.
+func (g *GetService) dependencyDownloads(folder string) []*chartDownload {
+	if g.dependencyState == nil {
+		return nil
+	}
+
+	var downloads []*chartDownload
+	for _, download := range g.dependencyState.downloads {
+		if path.Dir(download.path) == path.Clean(folder) {
+			downloads = append(downloads, download)
+		}
+	}
+
+	return downloads
+}
+
+// dependencyService returns the service mirroring the given version ranges of
+// the charts of repoURL. The dependencies living in the mirrored repository
+// are mirrored next to its charts, with its credentials; the other ones go to
+// the folder of their repository.
+func (g *GetService) dependencyService(repoURL string, ranges map[string][]*semver.Constraints) (*GetService, error) {
+	names := make([]string, 0, len(ranges))
+	for name := range ranges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	dependencyService := &GetService{
+		verbose:         g.verbose,
+		ignoreErrors:    g.ignoreErrors,
+		logger:          g.logger,
+		allVersions:     true,
+		chartNames:      names,
+		concurrency:     g.concurrency,
+		plainHTTP:       g.plainHTTP,
+		merge:           true,
+		retries:         g.retries,
+		retryWait:       g.retryWait,
+		connectTimeout:  g.connectTimeout,
+		timeout:         g.timeout,
+		requests:        g.requests,
+		bandwidth:       g.bandwidth,
+		proxyURL:        g.proxyURL,
+		noProxy:         g.noProxy,
+		dependencies:    true,
+		dependencyState: g.dependencyState,
+		versionRanges:   ranges,
+	}
+
+	root := g.dependencyState.root
+	if repoURL == strings.TrimSuffix(root.config.URL, dirSeparator) {
+		dependencyService.config = root.config
+		dependencyService.newRootURL = root.newRootURL
+		dependencyService.token = root.token
+		dependencyService.headers = root.headers
+	} else {
+		folder := dependencyFolder(repoURL)
+		dependencyService.config = repo.Entry{Name: path.Join(root.config.Name, folder), URL: repoURL}
+		if root.newRootURL != "" {
+			rootURL, err := urlutil.URLJoin(root.newRootURL, folder)
+			if err != nil {
+				return nil, fmt.Errorf("cannot build the root URL of the dependencies from %s: %w", repoURL, err)
+			}
+			dependencyService.newRootURL = rootURL
+		}
+	}
+
+	if strings.HasPrefix(repoURL, ociScheme+"://") {
+		dependencyService.chartNames = nil
+		dependencyService.ociCharts = names
+	}
+
+	return dependencyService, nil
+}
+
+// dependencyRepository returns the URL of the repository of a dependency,
+// without trailing slash, or false when it is not a repository URL.
+func dependencyRepository(repository string) (string, bool) {
+	repoURL, err := url.Parse(repository)
+	if err != nil {
+		return "", false
+	}
+
+	switch repoURL.Scheme {
+	case "http", "https", ociScheme:
+		return strings.TrimSuffix(repository, dirSeparator), repoURL.Host != ""
+	default:
+		return "", false
+	}
+}
+
+// dependencyFolder returns the folder the dependencies from repoURL are
+// mirrored to, relative to the destination folder: the host and path of the
+// repository under dependenciesFolder.
+func dependencyFolder(repoURL string) string {
+	u, err := url.Parse(repoURL)
+	if err != nil {
+		return path.Join(dependenciesFolder, "invalid")
+	}
+
+	folders := []string{dependenciesFolder, strings.ReplaceAll(u.Host, ":", "_")}
+	for _, folder := range strings.Split(u.Path, dirSeparator) {
+		if folder != "" && folder != "." && folder != ".." {
+			folders = append(folders, folder)
+		}
+	}
+
+	return path.Join(folders...)
+}
+
+// chartDependencies returns the dependencies declared by the chart archive at
+// name, read from the first of dependencyFiles declaring any.
+func chartDependencies(name string) ([]chartDependency, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open chart: %w", err)
+	}
+	defer file.Close()
+
+	archive, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read chart %q: %w", name, err)
+	}
+	defer archive.Close()
+
+	files := map[string][]byte{}
+	reader := tar.NewReader(archive)
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot read chart %q: %w", name, err)
+		}
+
+		// only the files of the chart itself, not the ones of its subcharts
+		folder, base, ok := strings.Cut(strings.TrimPrefix(header.Name, "./"), "/")
+		if !ok || folder == "" || !slices.Contains(dependencyFiles, base) {
+			continue
+		}
+
+		content, err := io.ReadAll(io.LimitReader(reader, maxDependencyFileSize))
+		if err != nil {
+			return nil, fmt.Errorf("cannot read %s of chart %q: %w", base, name, err)
+		}
+		files[base] = content
+	}
+
+	for _, base := range dependencyFiles {
+		content, ok := files[base]
+		if !ok {
+			continue
+		}
+
+		var declared struct {
+			Dependencies []chartDependency `json:"dependencies"`
+		}
+		if err := yaml.Unmarshal(content, &declared); err != nil {
+			return nil, fmt.Errorf("cannot parse %s of chart %q: %w", base, name, err)
+		}
+		if len(declared.Dependencies) > 0 {
+			return declared.Dependencies, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// newestInRanges keeps, for each chart of ranges and each of its version
+// ranges, the newest version satisfying the range. A nil range is satisfied
+// by any version. Versions are returned in their original order.
+func newestInRanges(versions []*repo.ChartVersion, ranges map[string][]*semver.Constraints) []*repo.ChartVersion {
+	keep := map[*repo.ChartVersion]bool{}
+	for name, constraints := range ranges {
+		var candidates repo.ChartVersions
+		for _, version := range versions {
+			if version.Name == name {
+				candidates = append(candidates, version)
+			}
+		}
+		sort.Stable(sort.Reverse(candidates))
+
+		for _, constraint := range constraints {
+			for _, candidate := range candidates {
+				if constraint == nil || matchesConstraint(constraint, candidate.Version) {
+					keep[candidate] = true
+					break
+				}
+			}
+		}
+	}
+
+	return slices.DeleteFunc(slices.Clone(versions), func(version *repo.ChartVersion) bool {
+		return !keep[version]
+	})
+}
+
+// newestTagsInRanges keeps, for each range, the newest of the OCI tags
+// satisfying it. Tags must be sorted newest first.
+func newestTagsInRanges(tags []string, ranges []*semver.Constraints) []string {
+	keep := map[string]bool{}
+	for _, constraint := range ranges {
+		for _, tag := range tags {
+			if constraint == nil || matchesConstraint(constraint, strings.ReplaceAll(tag, "_", "+")) {
+				keep[tag] = true
+				break
+			}
+		}
+	}
+
+	return slices.DeleteFunc(slices.Clone(tags), func(tag string) bool {
+		return !keep[tag]
+	})
+}
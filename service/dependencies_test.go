@@ -0,0 +1,239 @@
+package service
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/Masterminds/semver"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/repo"
+)
+
+// chartArchive returns a chart archive holding files, by path.
+func chartArchive(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	archive := tar.NewWriter(gz)
+	for name, content := range files {
+		if err := archive.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content))}); err != nil {
+			t.Fatalf("writing archive: %s", err)
+		}
+		if _, err := archive.Write([]byte(content)); err != nil {
+			t.Fatalf("writing archive: %s", err)
+		}
+	}
+	archive.Close()
+	gz.Close()
+
+	return buf.Bytes()
+}
+
+// newChartServer starts a chart repository serving charts, by file name,
+// with an index file listing them.
+func newChartServer(t *testing.T, charts map[string][]byte) *httptest.Server {
+	t.Helper()
+
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/")
+		if name == indexFileName {
+			index := repo.NewIndexFile()
+			for file, content := range charts {
+				chartName, version, _ := strings.Cut(strings.TrimSuffix(file, ".tgz"), "-")
+				sum := sha256.Sum256(content)
+				index.Add(&chart.Metadata{Name: chartName, Version: version}, file, srv.URL, hex.EncodeToString(sum[:]))
+			}
+			index.SortEntries()
+			content, _ := json.Marshal(index)
+			w.Write(content)
+			return
+		}
+
+		content, ok := charts[name]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(content)
+	}))
+
+	return srv
+}
+
+func Test_chartDependencies(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		files   map[string]string
+		want    []chartDependency
+		wantErr bool
+	}{
+		{"1", map[string]string{"app/Chart.yaml": "apiVersion: v2\nname: app\ndependencies:\n- name: db\n  version: ~1.0.0\n  repository: https://charts.example.com\n"},
+			[]chartDependency{{Name: "db", Version: "~1.0.0", Repository: "https://charts.example.com"}}, false},
+		{"2", map[string]string{
+			"app/Chart.yaml":        "apiVersion: v1\nname: app\n",
+			"app/requirements.yaml": "dependencies:\n- name: db\n  version: ~1.0.0\n  repository: https://charts.example.com\n",
+			"app/requirements.lock": "dependencies:\n- name: db\n  version: 1.0.3\n  repository: https://charts.example.com\n",
+		}, []chartDependency{{Name: "db", Version: "1.0.3", Repository: "https://charts.example.com"}}, false},
+		{"3", map[string]string{
+			"app/Chart.yaml":           "apiVersion: v2\nname: app\n",
+			"app/charts/db/Chart.yaml": "apiVersion: v2\nname: db\ndependencies:\n- name: cache\n  repository: https://charts.example.com\n",
+		}, nil, false},
+		{"4", map[string]string{"app/Chart.yaml": "dependencies: {name: db}"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := path.Join(dir, "chart-"+tt.name+".tgz")
+			if err := os.WriteFile(name, chartArchive(t, tt.files), 0o600); err != nil {
+				t.Errorf("writing chart: %s", err)
+			}
+			got, err := chartDependencies(name)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("chartDependencies() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("chartDependencies() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_dependencyRepository(t *testing.T) {
+	tests := []struct {
+		name       string
+		repository string
+		want       string
+		wantOK     bool
+	}{
+		{"1", "https://charts.example.com/stable/", "https://charts.example.com/stable", true},
+		{"2", "oci://registry.example.com/charts", "oci://registry.example.com/charts", true},
+		{"3", "", "", false},
+		{"4", "file://../db", "", false},
+		{"5", "@stable", "", false},
+		{"6", "alias:stable", "", false},
+		{"7", "https://", "https:/", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := dependencyRepository(tt.repository)
+			if ok != tt.wantOK || (ok && got != tt.want) {
+				t.Errorf("dependencyRepository() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func Test_dependencyFolder(t *testing.T) {
+	tests := []struct {
+		name    string
+		repoURL string
+		want    string
+	}{
+		{"1", "https://charts.example.com", "dependencies/charts.example.com"},
+		{"2", "https://charts.example.com:8443/helm/stable", "dependencies/charts.example.com_8443/helm/stable"},
+		{"3", "https://charts.example.com/../../etc", "dependencies/charts.example.com/etc"},
+		{"4", "oci://registry.example.com/charts", "dependencies/registry.example.com/charts"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dependencyFolder(tt.repoURL); got != tt.want {
+				t.Errorf("dependencyFolder() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_newestInRanges(t *testing.T) {
+	var versions []*repo.ChartVersion
+	for _, version := range []string{"2.0.0", "1.0.5", "1.0.0", "0.9.0"} {
+		versions = append(versions, &repo.ChartVersion{Metadata: &chart.Metadata{Name: "db", Version: version}})
+	}
+	versions = append(versions, &repo.ChartVersion{Metadata: &chart.Metadata{Name: "cache", Version: "1.0.0"}})
+
+	tilde, _ := semver.NewConstraint("~1.0.0")
+	exact, _ := semver.NewConstraint("0.9.0")
+	none, _ := semver.NewConstraint(">=3.0.0")
+	got := newestInRanges(versions, map[string][]*semver.Constraints{"db": {tilde, exact, none}, "other": {nil}})
+
+	var gotVersions []string
+	for _, version := range got {
+		gotVersions = append(gotVersions, version.Name+"-"+version.Version)
+	}
+	if want := []string{"db-1.0.5", "db-0.9.0"}; !reflect.DeepEqual(gotVersions, want) {
+		t.Errorf("newestInRanges() = %v, want %v", gotVersions, want)
+	}
+
+	if got := newestTagsInRanges([]string{"2.0.0", "1.0.5", "1.0.0"}, []*semver.Constraints{tilde, nil}); !reflect.DeepEqual(got, []string{"2.0.0", "1.0.5"}) {
+		t.Errorf("newestTagsInRanges() = %v, want [2.0.0 1.0.5]", got)
+	}
+}
+
+func TestGetService_GetDependencies(t *testing.T) {
+	var apps, libs *httptest.Server
+	libCharts := map[string][]byte{}
+	libs = newChartServer(t, libCharts)
+	defer libs.Close()
+	appCharts := map[string][]byte{}
+	apps = newChartServer(t, appCharts)
+	defer apps.Close()
+
+	appCharts["app-1.0.0.tgz"] = chartArchive(t, map[string]string{
+		"app/Chart.yaml": fmt.Sprintf("apiVersion: v2\nname: app\nversion: 1.0.0\ndependencies:\n- name: db\n  version: ~1.0.0\n  repository: %s/\n- name: local\n  repository: file://../local\n", libs.URL),
+	})
+	appCharts["cache-1.0.0.tgz"] = chartArchive(t, map[string]string{"cache/Chart.yaml": "apiVersion: v2\nname: cache\nversion: 1.0.0\n"})
+	appCharts["cache-2.0.0.tgz"] = chartArchive(t, map[string]string{"cache/Chart.yaml": "apiVersion: v2\nname: cache\nversion: 2.0.0\n"})
+	libCharts["db-1.0.0.tgz"] = chartArchive(t, map[string]string{"db/Chart.yaml": "apiVersion: v2\nname: db\nversion: 1.0.0\n"})
+	libCharts["db-1.0.5.tgz"] = chartArchive(t, map[string]string{
+		"db/Chart.yaml": fmt.Sprintf("apiVersion: v2\nname: db\nversion: 1.0.5\ndependencies:\n- name: cache\n  version: ^1.0.0\n  repository: %s\n", apps.URL),
+	})
+	libCharts["db-2.0.0.tgz"] = chartArchive(t, map[string]string{"db/Chart.yaml": "apiVersion: v2\nname: db\nversion: 2.0.0\n"})
+
+	dir := t.TempDir()
+
+	g := NewGetService(repo.Entry{Name: dir, URL: apps.URL}, false, false, false, fakeLogger, "", "app", "", WithDependencies())
+	if err := g.Get(); err != nil {
+		t.Errorf("GetService.Get() error = %v", err)
+	}
+
+	libsFolder := path.Join(dir, dependencyFolder(libs.URL))
+	want := map[string][]string{
+		dir:        {"app-1.0.0.tgz", "cache-1.0.0.tgz"},
+		libsFolder: {"db-1.0.5.tgz"},
+	}
+	for folder, wantCharts := range want {
+		index, err := repo.LoadIndexFile(path.Join(folder, indexFileName))
+		if err != nil {
+			t.Errorf("loading index of %s: %s", folder, err)
+			continue
+		}
+		var got []string
+		for _, versions := range index.Entries {
+			for _, version := range versions {
+				got = append(got, version.URLs...)
+				if !fileExists(path.Join(folder, version.URLs[0])) {
+					t.Errorf("chart %s listed in the index of %s is missing", version.URLs[0], folder)
+				}
+			}
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, wantCharts) {
+			t.Errorf("GetService.Get() %s = %v, want %v", folder, got, wantCharts)
+		}
+	}
+}
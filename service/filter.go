@@ -0,0 +1,206 @@
+package service
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver"
+	"k8s.io/helm/pkg/repo"
+)
+
+// regexpPatternPrefix marks chart name patterns that are regular expressions
+// rather than globs
+const regexpPatternPrefix = "re:"
+
+// namePattern reports whether a chart name matches a pattern
+type namePattern func(name string) bool
+
+// compileNamePattern compiles a glob, or an anchored regular expression when
+// pattern starts with regexpPatternPrefix.
+func compileNamePattern(pattern string) (namePattern, error) {
+	if expr, ok := strings.CutPrefix(pattern, regexpPatternPrefix); ok {
+		rexp, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid chart name regexp %q: %w", expr, err)
+		}
+		return rexp.MatchString, nil
+	}
+
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid chart name glob %q: %w", pattern, err)
+	}
+
+	return func(name string) bool {
+		matched, _ := path.Match(pattern, name)
+		return matched
+	}, nil
+}
+
+func compileNamePatterns(patterns []string) ([]namePattern, error) {
+	compiled := make([]namePattern, 0, len(patterns))
+	for _, pattern := range patterns {
+		matcher, err := compileNamePattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, matcher)
+	}
+
+	return compiled, nil
+}
+
+func matchesAny(patterns []namePattern, name string) bool {
+	for _, matches := range patterns {
+		if matches(name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// nameFilter returns the function selecting the charts to mirror by name: the
+// chart name and names given, when set, are matched exactly, then the include
+// and exclude patterns are applied. A chart name that is not a valid regular
+// expression is rejected, as it was when the index file was searched with it.
+func (g *GetService) nameFilter() (namePattern, error) {
+	if _, err := regexp.Compile(g.chartName); err != nil {
+		return nil, fmt.Errorf("invalid chart name %q: %w", g.chartName, err)
+	}
+
+	includes, err := compileNamePatterns(g.includes)
+	if err != nil {
+		return nil, err
+	}
+
+	excludes, err := compileNamePatterns(g.excludes)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(name string) bool {
+		if g.chartName != "" && name != g.chartName {
+			return false
+		}
+
+		if len(g.chartNames) > 0 && !slices.Contains(g.chartNames, name) {
+			return false
+		}
+
+		if len(includes) > 0 && !matchesAny(includes, name) {
+			return false
+		}
+
+		return !matchesAny(excludes, name)
+	}, nil
+}
+
+// parseVersionConstraint parses a semver constraint expression such as
+// ">=1.2.0 <2.0.0" or "~3.4". Besides the comma separated syntax understood
+// by semver, space separated terms are accepted and combined with AND, like
+// Helm does.
+func parseVersionConstraint(expr string) (*semver.Constraints, error) {
+	constraint, err := semver.NewConstraint(normalizeConstraint(expr))
+	if err != nil {
+		return nil, fmt.Errorf("invalid version constraint %q: %w", expr, err)
+	}
+
+	return constraint, nil
+}
+
+// normalizeConstraint rewrites space separated AND terms into the comma
+// separated form, keeping operators attached to their version and hyphen
+// ranges untouched.
+func normalizeConstraint(expr string) string {
+	alternatives := strings.Split(expr, "||")
+	for i, alternative := range alternatives {
+		fields := strings.Fields(strings.ReplaceAll(alternative, ",", " "))
+
+		var terms []string
+		for j := 0; j < len(fields); j++ {
+			field := fields[j]
+			switch {
+			case strings.Trim(field, "<>=!~^") == "" && j+1 < len(fields):
+				// operator separated from its version: ">= 1.2.0"
+				j++
+				field += fields[j]
+			case field == "-" && len(terms) > 0 && j+1 < len(fields):
+				// hyphen range: "1.2 - 1.4"
+				j++
+				terms[len(terms)-1] += " - " + fields[j]
+				continue
+			}
+			terms = append(terms, field)
+		}
+
+		alternatives[i] = strings.Join(terms, ", ")
+	}
+
+	return strings.Join(alternatives, " || ")
+}
+
+// matchesConstraint reports whether version satisfies constraint. Versions
+// that are not valid semver never match.
+func matchesConstraint(constraint *semver.Constraints, version string) bool {
+	parsed, err := semver.NewVersion(version)
+	if err != nil {
+		return false
+	}
+
+	return constraint.Check(parsed)
+}
+
+// isPrerelease reports whether version is a valid semver prerelease version.
+func isPrerelease(version string) bool {
+	parsed, err := semver.NewVersion(version)
+	if err != nil {
+		return false
+	}
+
+	return parsed.Prerelease() != ""
+}
+
+// versionsPerChart returns how many versions of each chart are kept after
+// filtering, zero meaning all of them. Besides an explicit --latest, a single
+// version is kept when every version was only loaded to skip prereleases.
+func (g *GetService) versionsPerChart(constraint *semver.Constraints) int {
+	if g.latest > 0 {
+		return g.latest
+	}
+
+	if g.excludePrereleases && !g.allVersions && g.chartVersion == "" && constraint == nil {
+		return 1
+	}
+
+	return 0
+}
+
+// latestVersions keeps the newest n versions of each chart, by semver order.
+// Charts are returned in the order they first appear in versions; versions
+// that are not valid semver are considered older than any valid one.
+func latestVersions(versions []*repo.ChartVersion, n int) []*repo.ChartVersion {
+	var names []string
+	byName := map[string]repo.ChartVersions{}
+	for _, version := range versions {
+		if _, ok := byName[version.Name]; !ok {
+			names = append(names, version.Name)
+		}
+		byName[version.Name] = append(byName[version.Name], version)
+	}
+
+	latest := make([]*repo.ChartVersion, 0, len(versions))
+	for _, name := range names {
+		chartVersions := byName[name]
+		sort.Stable(sort.Reverse(chartVersions))
+		if len(chartVersions) > n {
+			chartVersions = chartVersions[:n]
+		}
+		latest = append(latest, chartVersions...)
+	}
+
+	return latest
+}
@@ -0,0 +1,172 @@
+package service
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/repo"
+)
+
+func Test_normalizeConstraint(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		want string
+	}{
+		{"1", ">=1.2.0 <2.0.0", ">=1.2.0, <2.0.0"},
+		{"2", ">=1.2.0, <2.0.0", ">=1.2.0, <2.0.0"},
+		{"3", ">= 1.2.0 < 2.0.0", ">=1.2.0, <2.0.0"},
+		{"4", "~3.4", "~3.4"},
+		{"5", "1.2 - 1.4", "1.2 - 1.4"},
+		{"6", "~1.2 || >=2.1 <2.3", "~1.2 || >=2.1, <2.3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizeConstraint(tt.expr); got != tt.want {
+				t.Errorf("normalizeConstraint() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_matchesConstraint(t *testing.T) {
+	tests := []struct {
+		name       string
+		constraint string
+		version    string
+		want       bool
+	}{
+		{"1", ">=1.2.0 <2.0.0", "1.2.0", true},
+		{"2", ">=1.2.0 <2.0.0", "1.9.9", true},
+		{"3", ">=1.2.0 <2.0.0", "2.0.0", false},
+		{"4", ">=1.2.0 <2.0.0", "1.1.0", false},
+		{"5", "~3.4", "3.4.7", true},
+		{"6", "~3.4", "3.5.0", false},
+		{"7", "~3.4", "latest", false},
+		{"8", "1.2 - 1.4", "1.3.0", true},
+		{"9", "~1.2 || >=2.1 <2.3", "2.2.0", true},
+		{"10", "~1.2 || >=2.1 <2.3", "2.3.0", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			constraint, err := parseVersionConstraint(tt.constraint)
+			if err != nil {
+				t.Errorf("parseVersionConstraint() error = %v", err)
+				return
+			}
+			if got := matchesConstraint(constraint, tt.version); got != tt.want {
+				t.Errorf("matchesConstraint() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseVersionConstraint(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		wantErr bool
+	}{
+		{"1", ">=1.2.0 <2.0.0", false},
+		{"2", "~3.4", false},
+		{"3", "not a constraint", true},
+		{"4", ">=", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseVersionConstraint(tt.expr); (err != nil) != tt.wantErr {
+				t.Errorf("parseVersionConstraint() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_isPrerelease(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		want    bool
+	}{
+		{"1", "1.0.0", false},
+		{"2", "1.0.0-rc1", true},
+		{"3", "1.0.0+build.1", false},
+		{"4", "latest", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isPrerelease(tt.version); got != tt.want {
+				t.Errorf("isPrerelease() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_latestVersions(t *testing.T) {
+	versions := func(refs ...string) []*repo.ChartVersion {
+		result := []*repo.ChartVersion{}
+		for _, ref := range refs {
+			name, version, _ := strings.Cut(ref, "@")
+			result = append(result, &repo.ChartVersion{Metadata: &chart.Metadata{Name: name, Version: version}})
+		}
+		return result
+	}
+	tests := []struct {
+		name     string
+		versions []*repo.ChartVersion
+		n        int
+		want     []*repo.ChartVersion
+	}{
+		{"1", versions("a@1.0.0", "a@3.0.0", "a@2.0.0"), 2, versions("a@3.0.0", "a@2.0.0")},
+		{"2", versions("b@1.0.0", "a@1.0.0", "b@1.10.0", "b@1.9.0"), 1, versions("b@1.10.0", "a@1.0.0")},
+		{"3", versions("a@bad", "a@1.0.0"), 2, versions("a@1.0.0", "a@bad")},
+		{"4", versions("a@1.0.0"), 3, versions("a@1.0.0")},
+		{"5", versions(), 3, versions()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := latestVersions(tt.versions, tt.n); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("latestVersions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_compileNamePattern(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		wantErr bool
+		matches []string
+		misses  []string
+	}{
+		{"1", "nginx", false, []string{"nginx"}, []string{"nginx-ingress", "my-nginx"}},
+		{"2", "nginx*", false, []string{"nginx", "nginx-ingress"}, []string{"my-nginx"}},
+		{"3", "*-deprecated", false, []string{"redis-deprecated"}, []string{"redis"}},
+		{"4", "re:nginx", false, []string{"nginx"}, []string{"nginx-ingress", "my-nginx"}},
+		{"5", "re:(nginx|redis)-.+", false, []string{"nginx-ingress", "redis-ha"}, []string{"nginx", "my-redis-ha"}},
+		{"6", "re:a|b", false, []string{"a", "b"}, []string{"ab", "ba"}},
+		{"7", "re:(", true, nil, nil},
+		{"8", "[", true, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matches, err := compileNamePattern(tt.pattern)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("compileNamePattern() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			for _, name := range tt.matches {
+				if !matches(name) {
+					t.Errorf("compileNamePattern(%q) does not match %q", tt.pattern, name)
+				}
+			}
+			for _, name := range tt.misses {
+				if matches(name) {
+					t.Errorf("compileNamePattern(%q) matches %q", tt.pattern, name)
+				}
+			}
+		})
+	}
+}
@@ -1,23 +1,32 @@
 package service
 
 import (
-	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
 	"net/url"
 	"os"
 	"path"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
-	"k8s.io/helm/cmd/helm/search"
+	"github.com/Masterminds/semver"
 	"k8s.io/helm/pkg/getter"
-	"k8s.io/helm/pkg/helm/environment"
+	"k8s.io/helm/pkg/provenance"
 	"k8s.io/helm/pkg/repo"
+	"k8s.io/helm/pkg/urlutil"
 )
 
 const (
 	downloadedFileName = "downloaded-index.yaml"
 	indexFileName      = "index.yaml"
+	provenanceExt      = ".prov"
 	dirSeparator       = "/"
 )
 
@@ -28,19 +37,157 @@ type GetServiceInterface interface {
 
 // GetService structure definition
 type GetService struct {
-	config       repo.Entry
-	verbose      bool
-	ignoreErrors bool
-	logger       *log.Logger
-	newRootURL   string
-	allVersions  bool
-	chartName    string
-	chartVersion string
+	config             repo.Entry
+	verbose            bool
+	ignoreErrors       bool
+	logger             *log.Logger
+	newRootURL         string
+	allVersions        bool
+	chartName          string
+	chartVersion       string
+	chartNames         []string
+	includes           []string
+	excludes           []string
+	concurrency        int
+	provenance         bool
+	keyring            string
+	ociCharts          []string
+	plainHTTP          bool
+	publishers         []Publisher
+	constraint         string
+	latest             int
+	excludePrereleases bool
+	merge              bool
+	prune              bool
+	pruneDryRun        bool
+	dryRun             bool
+	planOut            io.Writer
+	planFormat         PlanFormat
+	reportPath         string
+	reportFormat       ReportFormat
+	attempted          []*chartDownload
+	retries            int
+	retryWait          time.Duration
+	connectTimeout     time.Duration
+	timeout            time.Duration
+	requests           *limiter
+	bandwidth          *limiter
+	token              string
+	headers            http.Header
+	proxyURL           string
+	noProxy            []string
+	cachedIndex        string
+	cacheMaxAge        time.Duration
+	dependencies       bool
+	dependencyState    *dependencyState
+	versionRanges      map[string][]*semver.Constraints
+	local              bool
+}
+
+// GetOption configures optional behavior of a GetService
+type GetOption func(*GetService)
+
+// WithChartNames only mirrors the charts with one of the given names. It
+// complements the single chart name given to NewGetService.
+func WithChartNames(names []string) GetOption {
+	return func(g *GetService) {
+		g.chartNames = names
+	}
+}
+
+// WithIncludes only mirrors the charts whose name matches one of the given
+// patterns. Patterns are globs (eg: "nginx-*"), or anchored regular
+// expressions when prefixed with "re:" (eg: "re:(nginx|redis)-.+").
+func WithIncludes(patterns []string) GetOption {
+	return func(g *GetService) {
+		g.includes = patterns
+	}
+}
+
+// WithExcludes never mirrors the charts whose name matches one of the given
+// patterns, even when they are included. Patterns use the same syntax as
+// WithIncludes.
+func WithExcludes(patterns []string) GetOption {
+	return func(g *GetService) {
+		g.excludes = patterns
+	}
+}
+
+// WithConcurrency sets how many charts are downloaded in parallel. Values
+// lower than one fall back to sequential downloads.
+func WithConcurrency(concurrency int) GetOption {
+	return func(g *GetService) {
+		g.concurrency = concurrency
+	}
+}
+
+// WithProvenance mirrors the provenance file published next to each chart.
+// Charts published without one are mirrored alone, unless keyring is not
+// empty: the signature of every downloaded chart is then verified against the
+// public keys it contains. OCI registries do not serve provenance files, so it
+// cannot be used with oci:// repositories.
+func WithProvenance(keyring string) GetOption {
+	return func(g *GetService) {
+		g.provenance = true
+		g.keyring = keyring
+	}
+}
+
+// WithOCICharts sets the charts pulled when the repository URL points to an
+// OCI registry (oci://registry/namespace). Each chart is given as name or
+// name:tag; without a tag, the newest version is pulled, or every version when
+// all versions are requested or a version constraint is set.
+func WithOCICharts(charts []string) GetOption {
+	return func(g *GetService) {
+		g.ociCharts = charts
+	}
+}
+
+// WithPlainHTTP talks to OCI registries over HTTP instead of HTTPS.
+func WithPlainHTTP() GetOption {
+	return func(g *GetService) {
+		g.plainHTTP = true
+	}
+}
+
+// WithVersionConstraint only mirrors the chart versions satisfying the given
+// semver constraint expression (eg: ">=1.2.0 <2.0.0" or "~3.4"). Every
+// matching version of each chart is mirrored.
+func WithVersionConstraint(constraint string) GetOption {
+	return func(g *GetService) {
+		g.constraint = constraint
+	}
+}
+
+// WithLatest only mirrors the newest n versions of each chart, by semver
+// order.
+func WithLatest(n int) GetOption {
+	return func(g *GetService) {
+		g.latest = n
+	}
+}
+
+// WithoutPrereleases never mirrors prerelease versions (eg: 1.0.0-rc1). When
+// only the latest version of each chart is mirrored, the newest stable one is
+// picked.
+func WithoutPrereleases() GetOption {
+	return func(g *GetService) {
+		g.excludePrereleases = true
+	}
+}
+
+// WithMerge keeps the charts listed in the existing index file of the
+// destination folder, as long as their archive is still there, instead of
+// replacing the index with the charts mirrored by this run.
+func WithMerge() GetOption {
+	return func(g *GetService) {
+		g.merge = true
+	}
 }
 
 // NewGetService return a new instace of GetService
-func NewGetService(config repo.Entry, allVersions bool, verbose bool, ignoreErrors bool, logger *log.Logger, newRootURL string, chartName string, chartVersion string) *GetService {
-	return &GetService{
+func NewGetService(config repo.Entry, allVersions bool, verbose bool, ignoreErrors bool, logger *log.Logger, newRootURL string, chartName string, chartVersion string, opts ...GetOption) *GetService {
+	g := &GetService{
 		config:       config,
 		verbose:      verbose,
 		ignoreErrors: ignoreErrors,
@@ -50,6 +197,33 @@ func NewGetService(config repo.Entry, allVersions bool, verbose bool, ignoreErro
 		chartName:    chartName,
 		chartVersion: chartVersion,
 	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	return g
+}
+
+// chartDownload describes a single chart version to be mirrored and the
+// outcome of downloading it.
+type chartDownload struct {
+	name     string
+	version  string
+	digest   string
+	urls     []string
+	path     string
+	size     int64
+	entry    *repo.ChartVersion
+	upToDate bool
+	started  bool
+	duration time.Duration
+	err      error
+}
+
+// mirrored reports whether the chart is present in the destination folder
+// after downloading, either because it was up to date or because it was
+// downloaded successfully.
+func (d *chartDownload) mirrored() bool {
+	return d.upToDate || (d.started && d.err == nil)
 }
 
 func (g *GetService) logVerbose(format string, args ...any) {
@@ -60,49 +234,158 @@ func (g *GetService) logVerbose(format string, args ...any) {
 
 // Get methods downloads the index file and the Helm charts to the working directory.
 func (g *GetService) Get() error {
-	chartRepo, err := repo.NewChartRepository(&g.config, getter.All(environment.EnvSettings{}))
+	started := time.Now()
+	err := g.get()
+	if g.reportPath != "" && !g.dryRun {
+		if reportErr := g.writeReport(started, err); reportErr != nil {
+			return errors.Join(err, reportErr)
+		}
+	}
+
+	return err
+}
+
+// get mirrors the charts, recording the downloads it attempts for the report.
+func (g *GetService) get() error {
+	if g.config.Name == "" {
+		return errors.New("no destination folder given")
+	}
+
+	var constraint *semver.Constraints
+	if g.constraint != "" {
+		var err error
+		if constraint, err = parseVersionConstraint(g.constraint); err != nil {
+			return err
+		}
+	}
+
+	if strings.HasPrefix(g.config.URL, ociScheme+"://") {
+		return g.getOCI(constraint)
+	}
+
+	if err := g.useLocalSource(); err != nil {
+		return err
+	}
+
+	chartRepo, err := repo.NewChartRepository(&g.config, g.getters())
 	if err != nil {
 		return fmt.Errorf("cannot construct chart repository: %w", err)
 	}
 
-	g.logVerbose("Downloading index file from %s", g.config.URL)
-	downloadedIndexPath := path.Join(g.config.Name, downloadedFileName)
-	if err := chartRepo.DownloadIndexFile(downloadedIndexPath); err != nil {
-		return fmt.Errorf("cannot download index file: %w", err)
+	indexFolder := g.config.Name
+	if g.dryRun {
+		if indexFolder, err = os.MkdirTemp("", "helm-mirror-"); err != nil {
+			return fmt.Errorf("cannot create temporary folder: %w", err)
+		}
+		defer os.RemoveAll(indexFolder)
 	}
 
-	g.logVerbose("Loading local directory %q as repository", g.config.Name)
-	if err := chartRepo.Load(); err != nil {
-		return fmt.Errorf("cannot load index file: %w", err)
+	downloadedIndexPath := path.Join(indexFolder, downloadedFileName)
+	if err := g.fetchIndexFile(chartRepo, downloadedIndexPath); err != nil {
+		return err
 	}
 
-	g.logVerbose("Creating a new local index and adding charts to it")
-	index := search.NewIndex()
-	index.AddRepo(chartRepo.Config.Name, chartRepo.IndexFile, (g.allVersions || g.chartVersion != ""))
+	g.logVerbose("Loading index file %q", downloadedIndexPath)
+	indexFile, err := repo.LoadIndexFile(downloadedIndexPath)
+	if err != nil {
+		return fmt.Errorf("cannot load index file: %w", err)
+	}
 
-	rexp := fmt.Sprintf("^.*%s.*", g.chartName)
-	g.logVerbose("Searching for regexp %q in index file", rexp)
+	var signatory *provenance.Signatory
+	if g.keyring != "" {
+		g.logVerbose("Loading keyring %q to verify chart signatures", g.keyring)
+		signatory, err = provenance.NewFromKeyring(g.keyring, "")
+		if err != nil {
+			return fmt.Errorf("cannot load keyring %q: %w", g.keyring, err)
+		}
+	}
 
-	results, err := index.Search(rexp, 1, true)
+	selectName, err := g.nameFilter()
 	if err != nil {
-		return fmt.Errorf("cannot search index file: %w", err)
+		return err
 	}
 
-	g.logVerbose("Found %d results from searching %q", len(results), rexp)
+	// without any version selection, only the newest version of each chart
+	// (the first one, the index being sorted) is mirrored
+	allVersions := g.allVersions || g.chartVersion != "" || constraint != nil || g.latest > 0 || g.excludePrereleases
 
-	for _, result := range results {
-		g.logVerbose("Processing chart %q (version %s)", result.Chart.Name, result.Chart.Version)
+	names := make([]string, 0, len(indexFile.Entries))
+	for name := range indexFile.Entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 
-		if g.chartName != "" && result.Chart.Name != g.chartName {
-			continue
+	var selected []*repo.ChartVersion
+	for _, name := range names {
+		chartVersions := indexFile.Entries[name]
+		if !allVersions && len(chartVersions) > 1 {
+			chartVersions = chartVersions[:1]
+		}
+
+		for _, chartVersion := range chartVersions {
+			g.logVerbose("Processing chart %q (version %s)", chartVersion.Name, chartVersion.Version)
+
+			if !selectName(chartVersion.Name) {
+				continue
+			}
+
+			if g.chartVersion != "" && chartVersion.Version != g.chartVersion {
+				continue
+			}
+
+			if constraint != nil && !matchesConstraint(constraint, chartVersion.Version) {
+				continue
+			}
+
+			if g.excludePrereleases && isPrerelease(chartVersion.Version) {
+				continue
+			}
+
+			selected = append(selected, chartVersion)
+		}
+	}
+
+	g.logVerbose("Selected %d chart versions out of %d charts in the index file", len(selected), len(names))
+
+	if keep := g.versionsPerChart(constraint); keep > 0 {
+		selected = latestVersions(selected, keep)
+	}
+
+	if g.versionRanges != nil {
+		selected = newestInRanges(selected, g.versionRanges)
+	}
+
+	downloads := make([]*chartDownload, 0, len(selected))
+	paths := map[string]*chartDownload{}
+	for _, chartVersion := range selected {
+		download := &chartDownload{
+			name:    chartVersion.Name,
+			version: chartVersion.Version,
+			digest:  chartVersion.Digest,
+			path:    path.Join(g.config.Name, fmt.Sprintf("%s-%s.tgz", chartVersion.Name, chartVersion.Version)),
+			entry:   chartVersion,
 		}
+		if first, ok := paths[download.path]; ok {
+			if first.digest == download.digest {
+				g.logVerbose("Skipping chart %q (version %s): listed more than once in the index file", download.name, download.version)
+				continue
+			}
 
-		if g.chartVersion != "" && result.Chart.Version != g.chartVersion {
+			// both entries would be written to the same file: only the first
+			// one is mirrored, and the other one fails
+			err := fmt.Errorf("chart %s(%s) is listed more than once in the index file with different digests", download.name, download.version)
+			if !g.ignoreErrors {
+				return err
+			}
+			download.started, download.err = true, err
+			downloads = append(downloads, download)
 			continue
 		}
+		paths[download.path] = download
+		download.upToDate = isUpToDate(download.path, download.digest) && (!g.provenance || fileExists(download.path+provenanceExt))
 
-		for _, val := range result.Chart.URLs {
-			g.logVerbose("Found chart URL %q for chart %q (version %s)", val, result.Chart.Name, result.Chart.Version)
+		for _, val := range chartVersion.URLs {
+			g.logVerbose("Found chart URL %q for chart %q (version %s)", val, chartVersion.Name, chartVersion.Version)
 
 			chartURL, err := url.Parse(val)
 			if err != nil {
@@ -111,38 +394,373 @@ func (g *GetService) Get() error {
 
 			if chartURL.Scheme == "" {
 				val = strings.TrimRight(g.config.URL, dirSeparator) + dirSeparator + val
+			} else if localURL, ok := g.localChartURL(chartURL); ok {
+				download.urls = append(download.urls, localURL)
 			}
 
-			g.logVerbose("Downloading chart %q (version %s) from %q", result.Chart.Name, result.Chart.Version, val)
+			download.urls = append(download.urls, val)
+		}
+
+		if len(download.urls) > 0 {
+			downloads = append(downloads, download)
+		}
+	}
+
+	if g.dryRun {
+		client, err := g.newHTTPGetter(g.config.URL)
+		if err != nil {
+			return err
+		}
+		return g.printPlan(downloads, func(download *chartDownload) int64 {
+			return g.remoteSize(client, download)
+		})
+	}
+
+	g.downloadCharts(chartRepo.Client, signatory, downloads)
+
+	if err := g.checkDownloads(downloads); err != nil {
+		return err
+	}
+
+	if err := g.publishCharts(downloads); err != nil {
+		return err
+	}
+
+	if g.dependencyState != nil {
+		g.dependencyState.downloads = append(g.dependencyState.downloads, downloads...)
+	}
+
+	index := mirroredIndex(downloads)
+	if err := g.mergeIndex(index); err != nil {
+		return err
+	}
+
+	g.logVerbose("Writing index file %q with %d mirrored charts", downloadedIndexPath, len(index.Entries))
+	if err := index.WriteFile(downloadedIndexPath, 0o644); err != nil {
+		return fmt.Errorf("cannot write index file: %w", err)
+	}
+
+	g.logVerbose("Preparing index file %q: pointing chart URLs to %q", g.config.Name, g.newRootURL)
+	if err := g.prepareIndexFile(g.config.Name, g.newRootURL); err != nil {
+		return fmt.Errorf("cannot prepare index file: %w", err)
+	}
+
+	if err := g.mirrorDependencies(downloads); err != nil {
+		return err
+	}
+
+	if err := g.pruneCharts(downloads); err != nil {
+		return err
+	}
+
+	g.logVerbose("Operation completed successfully")
+	return nil
+}
+
+// mirroredIndex returns an index holding the index entries of the charts
+// present in the destination folder, leaving out the ones that were not
+// selected or failed to download.
+func mirroredIndex(downloads []*chartDownload) *repo.IndexFile {
+	index := repo.NewIndexFile()
+	seen := map[string]bool{}
+	for _, download := range downloads {
+		if !download.mirrored() || download.entry == nil || seen[download.path] {
+			continue
+		}
+		seen[download.path] = true
+		index.Entries[download.entry.Name] = append(index.Entries[download.entry.Name], download.entry)
+	}
+	index.SortEntries()
+
+	return index
+}
+
+// mergeIndex adds to index the charts listed in the existing index file of
+// the destination folder when merging, unless index already lists them or
+// their archive is gone. Their URLs are pointed at the mirrored archives.
+func (g *GetService) mergeIndex(index *repo.IndexFile) error {
+	indexPath := path.Join(g.config.Name, indexFileName)
+	if !g.merge || !fileExists(indexPath) {
+		return nil
+	}
+
+	g.logVerbose("Merging index file %q", indexPath)
+	existing, err := repo.LoadIndexFile(indexPath)
+	if err != nil {
+		return fmt.Errorf("cannot load existing index file: %w", err)
+	}
+
+	kept := repo.NewIndexFile()
+	for name, chartVersions := range existing.Entries {
+		for _, chartVersion := range chartVersions {
+			filename := fmt.Sprintf("%s-%s.tgz", chartVersion.Name, chartVersion.Version)
+			if !fileExists(path.Join(g.config.Name, filename)) {
+				g.logVerbose("Dropping chart %q (version %s) from index file: %q is missing", chartVersion.Name, chartVersion.Version, filename)
+				continue
+			}
 
-			buf, err := chartRepo.Client.Get(val)
+			chartURL, err := mirroredChartURL(g.newRootURL, chartVersion)
 			if err != nil {
-				if g.ignoreErrors {
-					g.logger.Printf("WARNING: processing chart %s(%s) - %s", result.Name, result.Chart.Version, err)
+				return err
+			}
+			chartVersion.URLs = []string{chartURL}
+			kept.Entries[name] = append(kept.Entries[name], chartVersion)
+		}
+	}
+
+	index.Merge(kept)
+	index.SortEntries()
+	return nil
+}
+
+// checkDownloads logs the outcome of downloads in order and returns the
+// errors found, unless errors are being ignored.
+func (g *GetService) checkDownloads(downloads []*chartDownload) error {
+	var errs []error
+	for _, download := range downloads {
+		if download.upToDate {
+			g.logVerbose("Skipping chart %q (version %s): %q is up to date", download.name, download.version, download.path)
+			continue
+		}
+
+		if !download.started {
+			continue
+		}
+
+		if download.err == nil {
+			g.logVerbose("Wrote chart %q (version %s) to %q", download.name, download.version, download.path)
+			continue
+		}
+
+		if g.ignoreErrors {
+			g.logger.Printf("WARNING: processing chart %s(%s) - %s", download.name, download.version, download.err)
+			continue
+		}
+		errs = append(errs, download.err)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("cannot mirror charts: %w", errors.Join(errs...))
+	}
+
+	return nil
+}
+
+// downloadCharts fetches and writes the given charts using a bounded pool of
+// workers. The outcome of each download is stored in the chartDownload itself
+// so callers can report them in a deterministic order. Unless errors are being
+// ignored, no new downloads are started once one of them has failed. The
+// downloads are kept for the run report.
+func (g *GetService) downloadCharts(client getter.Getter, signatory *provenance.Signatory, downloads []*chartDownload) {
+	g.attempted = downloads
+
+	workers := g.concurrency
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(downloads) {
+		workers = len(downloads)
+	}
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed bool
+	)
+
+	queue := make(chan *chartDownload)
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for download := range queue {
+				mu.Lock()
+				stop := failed
+				mu.Unlock()
+				if stop {
 					continue
 				}
-				return fmt.Errorf("cannot download chart %s(%s): %w", result.Name, result.Chart.Version, err)
+
+				download.started = true
+				start := time.Now()
+				download.err = g.downloadChart(client, signatory, download)
+				download.duration = time.Since(start)
+				if download.err != nil && !g.ignoreErrors {
+					mu.Lock()
+					failed = true
+					mu.Unlock()
+				}
 			}
+		}()
+	}
 
-			chartFileName := fmt.Sprintf("%s-%s.tgz", result.Chart.Name, result.Chart.Version)
-			chartPath := path.Join(g.config.Name, chartFileName)
+	for _, download := range downloads {
+		if download.upToDate || download.err != nil {
+			continue
+		}
 
-			g.logVerbose("Writing chart %q (version %s) to %q", result.Chart.Name, result.Chart.Version, chartPath)
-			if err := g.writeFile(chartPath, buf.Bytes()); err != nil {
-				return fmt.Errorf("cannot write chart %s(%s): %w", result.Name, result.Chart.Version, err)
-			}
+		mu.Lock()
+		stop := failed
+		mu.Unlock()
+		if stop {
+			break
 		}
+
+		g.logVerbose("Downloading chart %q (version %s) from %q", download.name, download.version, download.urls)
+		queue <- download
 	}
+	close(queue)
+	wg.Wait()
+}
 
-	g.logVerbose("Preparing index file %q: rewriting URL: %q->%q", g.config.Name, g.config.URL, g.newRootURL)
-	if err := g.prepareIndexFile(g.config.Name, g.config.URL, g.newRootURL); err != nil {
-		return fmt.Errorf("cannot prepare index file: %w", err)
+// downloadChart fetches a chart from the first of its URLs that answers with
+// content matching the index digest and writes it to its destination path.
+// When provenance files are mirrored, they are written next to the chart and
+// verified with signatory if one is given.
+func (g *GetService) downloadChart(client getter.Getter, signatory *provenance.Signatory, download *chartDownload) error {
+	var errs []error
+	for _, val := range download.urls {
+		chart, prov, err := g.fetchChart(client, val, download.digest)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", val, err))
+			continue
+		}
+
+		if err := writeChart(signatory, download.path, chart, prov); err != nil {
+			return fmt.Errorf("cannot write chart %s(%s): %w", download.name, download.version, err)
+		}
+		download.size = int64(len(chart))
+		return nil
+	}
+
+	return fmt.Errorf("cannot download chart %s(%s): %w", download.name, download.version, errors.Join(errs...))
+}
+
+// writeChart writes a chart and its provenance file, unless prov is nil, to
+// chartPath. They are written to a temporary folder next to it and verified
+// with signatory, if one is given, before being moved into place, so a chart
+// failing verification never replaces the copy already mirrored.
+func writeChart(signatory *provenance.Signatory, chartPath string, chart []byte, prov []byte) error {
+	staging, err := os.MkdirTemp(path.Dir(chartPath), ".download-")
+	if err != nil {
+		return fmt.Errorf("cannot create temporary folder: %w", err)
+	}
+	defer os.RemoveAll(staging)
+
+	stagedChart := path.Join(staging, path.Base(chartPath))
+	if err := os.WriteFile(stagedChart, chart, 0o600); err != nil {
+		return fmt.Errorf("cannot write chart: %w", err)
+	}
+
+	if prov == nil {
+		return renameFile(stagedChart, chartPath)
+	}
+
+	stagedProv := stagedChart + provenanceExt
+	if err := os.WriteFile(stagedProv, prov, 0o600); err != nil {
+		return fmt.Errorf("cannot write provenance file: %w", err)
+	}
+
+	if signatory != nil {
+		if _, err := signatory.Verify(stagedChart, stagedProv); err != nil {
+			return fmt.Errorf("cannot verify signature: %w", err)
+		}
+	}
+
+	if err := renameFile(stagedChart, chartPath); err != nil {
+		return err
+	}
+
+	return renameFile(stagedProv, chartPath+provenanceExt)
+}
+
+func renameFile(from string, to string) error {
+	if err := os.Rename(from, to); err != nil {
+		return fmt.Errorf("cannot move %q into place: %w", path.Base(to), err)
+	}
+
+	return nil
+}
+
+// fetchChart downloads a chart archive from chartURL, checks it against
+// digest and, when provenance files are mirrored, downloads its provenance
+// file too. Without a keyring to verify it against, a missing provenance file
+// only means the chart is not signed, and a nil one is returned.
+func (g *GetService) fetchChart(client getter.Getter, chartURL string, digest string) ([]byte, []byte, error) {
+	chart, err := client.Get(chartURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot download chart: %w", err)
+	}
+
+	if err := verifyDigest(chart.Bytes(), digest); err != nil {
+		return nil, nil, err
+	}
+
+	if !g.provenance {
+		return chart.Bytes(), nil, nil
+	}
+
+	prov, err := client.Get(chartURL + provenanceExt)
+	if err != nil && g.keyring == "" && isNotFound(err) {
+		g.logVerbose("No provenance file for chart %q: %s", chartURL, err)
+		return chart.Bytes(), nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot download provenance file: %w", err)
+	}
+
+	return chart.Bytes(), prov.Bytes(), nil
+}
+
+// isNotFound reports whether err comes from a getter asked for a file that
+// does not exist, either on an HTTP server or on disk.
+func isNotFound(err error) bool {
+	var status *statusError
+	if errors.As(err, &status) {
+		return status.code == http.StatusNotFound
+	}
+
+	return errors.Is(err, os.ErrNotExist)
+}
+
+// isUpToDate reports whether the file at chartPath exists and matches the
+// digest advertised by the index. Charts without a digest are never considered
+// up to date.
+func isUpToDate(chartPath string, digest string) bool {
+	if digest == "" {
+		return false
+	}
+
+	localDigest, err := provenance.DigestFile(chartPath)
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(localDigest, strings.TrimPrefix(digest, "sha256:"))
+}
+
+// verifyDigest checks that the SHA-256 of content matches the digest listed in
+// the index. Charts without a digest in the index cannot be verified and are
+// accepted as is.
+func verifyDigest(content []byte, digest string) error {
+	if digest == "" {
+		return nil
+	}
+
+	sum := sha256.Sum256(content)
+	got := hex.EncodeToString(sum[:])
+	if !strings.EqualFold(got, strings.TrimPrefix(digest, "sha256:")) {
+		return fmt.Errorf("digest mismatch: got %s, want %s", got, digest)
 	}
 
-	g.logVerbose("Operation completed successfully")
 	return nil
 }
 
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
 func (g *GetService) writeFile(name string, content []byte) error {
 	if err := os.WriteFile(name, content, 0o600); err != nil {
 		if g.ignoreErrors {
@@ -155,25 +773,50 @@ func (g *GetService) writeFile(name string, content []byte) error {
 	return nil
 }
 
-func (g *GetService) prepareIndexFile(folder string, repoURL string, newRootURL string) error {
+// prepareIndexFile saves the downloaded index file of folder as its index
+// file, with the URLs of every chart pointing at the mirrored archive: under
+// newRootURL, or relative to the index file when newRootURL is empty.
+func (g *GetService) prepareIndexFile(folder string, newRootURL string) error {
 	downloadedPath := path.Join(folder, downloadedFileName)
 	indexPath := path.Join(folder, indexFileName)
 
-	if newRootURL != "" {
-		indexContent, err := os.ReadFile(downloadedPath)
-		if err != nil {
-			return fmt.Errorf("cannot read index file: %w", err)
-		}
+	index, err := repo.LoadIndexFile(downloadedPath)
+	if err != nil {
+		return fmt.Errorf("cannot load index file: %w", err)
+	}
 
-		content := bytes.ReplaceAll(indexContent, []byte(repoURL), []byte(newRootURL))
-		if err := g.writeFile(downloadedPath, content); err != nil {
-			//nolint:nilerr // ignore error
-			return nil
+	for _, chartVersions := range index.Entries {
+		for _, chartVersion := range chartVersions {
+			chartURL, err := mirroredChartURL(newRootURL, chartVersion)
+			if err != nil {
+				return err
+			}
+			chartVersion.URLs = []string{chartURL}
 		}
 	}
 
-	if err := os.Rename(downloadedPath, indexPath); err != nil {
-		return fmt.Errorf("cannot rename index file: %w", err)
+	if err := index.WriteFile(indexPath, 0o644); err != nil {
+		return fmt.Errorf("cannot write index file: %w", err)
+	}
+
+	if err := os.Remove(downloadedPath); err != nil {
+		return fmt.Errorf("cannot remove downloaded index file: %w", err)
 	}
 	return nil
 }
+
+// mirroredChartURL returns the URL of the mirrored archive of chartVersion,
+// under newRootURL or relative when newRootURL is empty.
+func mirroredChartURL(newRootURL string, chartVersion *repo.ChartVersion) (string, error) {
+	filename := fmt.Sprintf("%s-%s.tgz", chartVersion.Name, chartVersion.Version)
+	if newRootURL == "" {
+		return filename, nil
+	}
+
+	chartURL, err := urlutil.URLJoin(newRootURL, filename)
+	if err != nil {
+		return "", fmt.Errorf("cannot build URL of chart %s(%s) under %q: %w", chartVersion.Name, chartVersion.Version, newRootURL, err)
+	}
+
+	return chartURL, nil
+}
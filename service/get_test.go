@@ -3,14 +3,18 @@ package service
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log"
 	"os"
 	"path"
 	"reflect"
+	"sort"
 	"strings"
 	"testing"
 
 	"github.com/konstructio/helm-mirror/fixtures"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/provenance"
 	"k8s.io/helm/pkg/repo"
 )
 
@@ -23,11 +27,7 @@ func (m *mockLog) Write(p []byte) (n int, err error) {
 }
 
 func TestNewGetService(t *testing.T) {
-	dir, err := os.MkdirTemp("", "helmmirrortests")
-	if err != nil {
-		t.Errorf("Creating tmp directory: %s", err)
-	}
-	defer os.RemoveAll(dir)
+	dir := t.TempDir()
 	config := repo.Entry{Name: dir, URL: "http://helmrepo"}
 	gService := &GetService{config: config, logger: fakeLogger, newRootURL: "https://newchartserver.com", allVersions: false}
 	type args struct {
@@ -95,6 +95,8 @@ func TestGetService_Get(t *testing.T) {
 		{"12", fields{"http://127.0.0.1:1793", path.Join(dir, "get"), true, true, false, "chart2", "0.0.0-rc1"}, false, 1},
 		{"13", fields{"http://127.0.0.1:1793", path.Join(dir, "get"), true, true, true, "chart2", ""}, false, 2},
 		{"14", fields{"http://127.0.0.1:1793", path.Join(dir, "get"), true, true, true, "chart2", "0.0.0-rc1"}, false, 1},
+		{"15", fields{"http://127.0.0.1:1793", path.Join(dir, "get"), true, true, true, "chart3", ""}, false, 1},
+		{"16", fields{"http://127.0.0.1:1793", path.Join(dir, "get"), false, true, true, "chart3", ""}, true, 0},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -169,7 +171,6 @@ func Test_prepareIndexFile(t *testing.T) {
 	defer os.RemoveAll(dir)
 	type args struct {
 		folder       string
-		URL          string
 		newRootURL   string
 		log          *log.Logger
 		ignoreErrors bool
@@ -179,9 +180,9 @@ func Test_prepareIndexFile(t *testing.T) {
 		args    args
 		wantErr bool
 	}{
-		{"1", args{path.Join(dir, "processfolder"), "http://127.0.0.1:1793", "http://newchart.server.com", fakeLogger, false}, false},
-		{"2", args{path.Join(dir, "processerrorfolder"), "http://127.0.0.1:1793", "http://newchart.server.com", fakeLogger, false}, true},
-		{"3", args{path.Join(dir, "processfolder"), "http://127.0.0.1:1793", "", fakeLogger, false}, false},
+		{"1", args{path.Join(dir, "processfolder"), "http://newchart.server.com", fakeLogger, false}, false},
+		{"2", args{path.Join(dir, "processerrorfolder"), "http://newchart.server.com", fakeLogger, false}, true},
+		{"3", args{path.Join(dir, "processfolder"), "", fakeLogger, false}, false},
 	}
 	for _, tt := range tests {
 		os.WriteFile(path.Join(dir, "processfolder", "downloaded-index.yaml"), []byte(fixtures.IndexYaml), 0o666)
@@ -191,7 +192,7 @@ func Test_prepareIndexFile(t *testing.T) {
 				ignoreErrors: tt.args.ignoreErrors,
 			}
 
-			if err := g.prepareIndexFile(tt.args.folder, tt.args.URL, tt.args.newRootURL); (err != nil) != tt.wantErr {
+			if err := g.prepareIndexFile(tt.args.folder, tt.args.newRootURL); (err != nil) != tt.wantErr {
 				t.Errorf("prepareIndexFile() error = %v, wantErr %v", err, tt.wantErr)
 			}
 
@@ -205,11 +206,381 @@ func Test_prepareIndexFile(t *testing.T) {
 				if count != fixtures.Expectedcharts {
 					t.Errorf("prepareIndexFile() replacedCount = %v, want replacedCount %v", count, fixtures.Expectedcharts)
 				}
+				if info, err := os.Stat(path.Join(dir, "processfolder", "index.yaml")); err != nil || info.Mode().Perm()&0o044 != 0o044 {
+					t.Errorf("prepareIndexFile() index.yaml is not readable by everyone: %v", info)
+				}
 				_, err = os.Stat(path.Join(dir, "processfolder", "downloaded-index.yaml"))
 				if err == nil {
 					t.Errorf("prepareIndexFile() dowloaded-index.yaml not deleted")
 				}
 			}
+
+			if tt.name == "3" {
+				index, err := repo.LoadIndexFile(path.Join(dir, "processfolder", "index.yaml"))
+				if err != nil {
+					t.Errorf("prepareIndexFile() cannot load index.yaml: %s", err)
+					return
+				}
+				for _, chartVersions := range index.Entries {
+					for _, chartVersion := range chartVersions {
+						want := []string{fmt.Sprintf("%s-%s.tgz", chartVersion.Name, chartVersion.Version)}
+						if !reflect.DeepEqual(chartVersion.URLs, want) {
+							t.Errorf("prepareIndexFile() URLs = %v, want %v", chartVersion.URLs, want)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestGetService_downloadCharts(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name         string
+		concurrency  int
+		ignoreErrors bool
+		failURLs     map[string]bool
+		wantErrs     int
+		wantStarted  int
+	}{
+		{"1", 0, false, nil, 0, 5},
+		{"2", 3, false, nil, 0, 5},
+		{"3", 10, true, map[string]bool{"http://repo/chart2-1.0.0.tgz": true}, 1, 5},
+		{"4", 1, false, map[string]bool{"http://repo/chart0-1.0.0.tgz": true}, 1, 1},
+		{"5", 2, false, nil, 0, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			downloads := make([]*chartDownload, 0, 5)
+			for i := 0; i < 5; i++ {
+				name := fmt.Sprintf("chart%d", i)
+				downloads = append(downloads, &chartDownload{
+					name:    name,
+					version: "1.0.0",
+					urls:    []string{fmt.Sprintf("http://repo/%s-1.0.0.tgz", name)},
+					path:    path.Join(dir, name+"-1.0.0.tgz"),
+				})
+			}
+			downloads[4].upToDate = tt.name == "5"
+			g := &GetService{
+				logger:       fakeLogger,
+				ignoreErrors: tt.ignoreErrors,
+				concurrency:  tt.concurrency,
+			}
+			g.downloadCharts(&mockGetter{content: []byte("chart"), failURLs: tt.failURLs}, nil, downloads)
+			errs, started := 0, 0
+			for _, d := range downloads {
+				if d.started {
+					started++
+				}
+				if d.err != nil {
+					errs++
+				}
+			}
+			if errs != tt.wantErrs {
+				t.Errorf("GetService.downloadCharts() errors = %v, want %v", errs, tt.wantErrs)
+			}
+			if started != tt.wantStarted {
+				t.Errorf("GetService.downloadCharts() started = %v, want %v", started, tt.wantStarted)
+			}
+		})
+	}
+}
+
+func Test_isUpToDate(t *testing.T) {
+	dir := t.TempDir()
+	chartPath := path.Join(dir, "chart-1.0.0.tgz")
+	os.WriteFile(chartPath, []byte("chart"), 0o600)
+	digest := "8cc99f9cb669171776f7c6ec66069907579be91179f9201725fc6fc6f9ef1f29"
+	localDigest, err := provenance.DigestFile(chartPath)
+	if err != nil {
+		t.Errorf("digesting file: %s", err)
+	}
+	tests := []struct {
+		name      string
+		chartPath string
+		digest    string
+		want      bool
+	}{
+		{"1", chartPath, localDigest, true},
+		{"2", chartPath, "sha256:" + localDigest, true},
+		{"3", chartPath, strings.ToUpper(localDigest), true},
+		{"4", chartPath, digest, false},
+		{"5", chartPath, "", false},
+		{"6", path.Join(dir, "missing-1.0.0.tgz"), localDigest, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isUpToDate(tt.chartPath, tt.digest); got != tt.want {
+				t.Errorf("isUpToDate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_verifyDigest(t *testing.T) {
+	digest := "cc57fc1903e444cf6a726490b43b27ee9f87facc037f86872201847c565b45fb"
+	tests := []struct {
+		name    string
+		content []byte
+		digest  string
+		wantErr bool
+	}{
+		{"1", []byte("chart"), digest, false},
+		{"2", []byte("chart"), "sha256:" + digest, false},
+		{"3", []byte("chart"), strings.ToUpper(digest), false},
+		{"4", []byte("tampered"), digest, true},
+		{"5", []byte("chart"), "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := verifyDigest(tt.content, tt.digest); (err != nil) != tt.wantErr {
+				t.Errorf("verifyDigest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGetService_downloadChart(t *testing.T) {
+	dir, err := prepareTmp()
+	if err != nil {
+		t.Errorf("loading testdata: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Errorf("getting working directory: %s", err)
+	}
+	signer, err := provenance.NewFromFiles(path.Join(wd, "testdata", "keys", "helm-test-key.secret"), path.Join(wd, "testdata", "keys", "helm-test-key.pub"))
+	if err != nil {
+		t.Errorf("loading signing key: %s", err)
+	}
+	verifier, err := provenance.NewFromKeyring(path.Join(wd, "testdata", "keys", "helm-test-key.pub"), "")
+	if err != nil {
+		t.Errorf("loading keyring: %s", err)
+	}
+	signedPath := path.Join(dir, "signtest-0.1.0.tgz")
+	chart, err := os.ReadFile(path.Join(dir, "processtgz", "chart1.tgz"))
+	if err != nil {
+		t.Errorf("reading chart: %s", err)
+	}
+	os.WriteFile(signedPath, chart, 0o600)
+	prov, err := signer.ClearSign(signedPath)
+	if err != nil {
+		t.Errorf("signing chart: %s", err)
+	}
+	digest, err := provenance.DigestFile(signedPath)
+	if err != nil {
+		t.Errorf("digesting chart: %s", err)
+	}
+	chartURL := "http://repo/signtest-0.1.0.tgz"
+	tests := []struct {
+		name       string
+		provenance bool
+		signatory  *provenance.Signatory
+		files      map[string][]byte
+		failURLs   map[string]bool
+		wantErr    bool
+		wantProv   bool
+	}{
+		{"1", false, nil, map[string][]byte{chartURL: chart}, nil, false, false},
+		{"2", true, nil, map[string][]byte{chartURL: chart, chartURL + ".prov": []byte(prov)}, nil, false, true},
+		{"3", true, verifier, map[string][]byte{chartURL: chart, chartURL + ".prov": []byte(prov)}, nil, false, true},
+		{"4", true, verifier, map[string][]byte{chartURL: chart, chartURL + ".prov": []byte("not signed")}, nil, true, false},
+		{"5", true, nil, map[string][]byte{chartURL: chart}, map[string]bool{chartURL + ".prov": true}, true, false},
+		{"6", false, nil, map[string][]byte{chartURL: []byte("tampered")}, nil, true, false},
+		{"7", true, nil, map[string][]byte{chartURL: chart}, nil, false, false},
+		{"8", true, verifier, map[string][]byte{chartURL: chart}, nil, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workDir := path.Join(dir, "get")
+			download := &chartDownload{
+				name:    "signtest",
+				version: "0.1.0",
+				digest:  digest,
+				urls:    []string{chartURL},
+				path:    path.Join(workDir, "signtest-0.1.0.tgz"),
+			}
+			g := &GetService{
+				logger:     fakeLogger,
+				provenance: tt.provenance,
+			}
+			if tt.signatory != nil {
+				g.keyring = "keyring.gpg"
+			}
+			client := &mockGetter{files: tt.files, failURLs: tt.failURLs, missing: map[string]bool{chartURL + ".prov": tt.files[chartURL+".prov"] == nil}}
+			os.WriteFile(download.path, []byte("previous"), 0o600)
+			if err := g.downloadChart(client, tt.signatory, download); (err != nil) != tt.wantErr {
+				t.Errorf("GetService.downloadChart() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got, _ := os.ReadFile(download.path); tt.wantErr != (string(got) == "previous") {
+				t.Errorf("GetService.downloadChart() left chart %.20q, wantErr %v", got, tt.wantErr)
+			}
+			if got := fileExists(download.path + ".prov"); got != tt.wantProv {
+				t.Errorf("GetService.downloadChart() provenance written = %v, want %v", got, tt.wantProv)
+			}
+			os.Remove(download.path)
+			os.Remove(download.path + ".prov")
+		})
+	}
+}
+
+func Test_mirroredChartURL(t *testing.T) {
+	chartVersion := &repo.ChartVersion{Metadata: &chart.Metadata{Name: "nginx", Version: "1.0.0"}}
+	tests := []struct {
+		name       string
+		newRootURL string
+		want       string
+		wantErr    bool
+	}{
+		{"1", "", "nginx-1.0.0.tgz", false},
+		{"2", "https://mirror.local.lan/charts", "https://mirror.local.lan/charts/nginx-1.0.0.tgz", false},
+		{"3", "https://mirror.local.lan/charts/", "https://mirror.local.lan/charts/nginx-1.0.0.tgz", false},
+		{"4", "https://mirror.local.lan", "https://mirror.local.lan/nginx-1.0.0.tgz", false},
+		{"5", "://mirror.local.lan", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mirroredChartURL(tt.newRootURL, chartVersion)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("mirroredChartURL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("mirroredChartURL() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetService_GetWithOptions(t *testing.T) {
+	svr := fixtures.NewTestRepositoryServer(t)
+	type fields struct {
+		allVersions  bool
+		ignoreErrors bool
+		chartName    string
+		opts         []GetOption
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		wantErr bool
+		wantTgz []string
+	}{
+		{"1", fields{false, true, "", nil}, false, []string{"chart1-2.11.0.tgz", "chart2-1.0.1.tgz", "chart3-0.0.1-rc1.tgz"}},
+		{"2", fields{false, true, "", []GetOption{WithVersionConstraint(">=1.0.0 <3.0.0")}}, false, []string{"chart1-2.11.0.tgz", "chart2-1.0.1.tgz"}},
+		{"3", fields{false, true, "", []GetOption{WithVersionConstraint("~1.0")}}, false, []string{"chart2-1.0.1.tgz"}},
+		{"4", fields{false, true, "chart2", []GetOption{WithVersionConstraint(">=0.0.0-0")}}, false, []string{"chart2-0.0.0-rc1.tgz", "chart2-1.0.1.tgz"}},
+		{"5", fields{false, true, "", []GetOption{WithVersionConstraint("not a constraint")}}, true, nil},
+		{"6", fields{false, true, "", []GetOption{WithLatest(1)}}, false, []string{"chart1-2.11.0.tgz", "chart2-1.0.1.tgz", "chart3-0.0.1-rc1.tgz"}},
+		{"7", fields{false, true, "", []GetOption{WithLatest(2)}}, false, []string{"chart1-2.11.0.tgz", "chart2-0.0.0-rc1.tgz", "chart2-1.0.1.tgz", "chart3-0.0.1-rc1.tgz"}},
+		{"8", fields{false, true, "", []GetOption{WithoutPrereleases()}}, false, []string{"chart1-2.11.0.tgz", "chart2-1.0.1.tgz"}},
+		{"9", fields{true, true, "", []GetOption{WithLatest(2), WithoutPrereleases()}}, false, []string{"chart1-2.11.0.tgz", "chart2-1.0.1.tgz"}},
+		{"10", fields{false, true, "", []GetOption{WithChartNames([]string{"chart1", "chart3"})}}, false, []string{"chart1-2.11.0.tgz", "chart3-0.0.1-rc1.tgz"}},
+		{"11", fields{false, true, "", []GetOption{WithIncludes([]string{"chart[12]"})}}, false, []string{"chart1-2.11.0.tgz", "chart2-1.0.1.tgz"}},
+		{"12", fields{false, true, "", []GetOption{WithExcludes([]string{"re:chart(1|3)"})}}, false, []string{"chart2-1.0.1.tgz"}},
+		{"13", fields{true, true, "", []GetOption{WithIncludes([]string{"chart*"}), WithExcludes([]string{"chart1"})}}, false, []string{"chart2-0.0.0-rc1.tgz", "chart2-1.0.1.tgz", "chart3-0.0.1-rc1.tgz"}},
+		{"14", fields{false, true, "", []GetOption{WithIncludes([]string{"re:chart("})}}, true, nil},
+		{"15", fields{false, true, "", []GetOption{WithExcludes([]string{"chart["})}}, true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			g := NewGetService(repo.Entry{Name: dir, URL: svr.URL}, tt.fields.allVersions, false, tt.fields.ignoreErrors, fakeLogger, "", tt.fields.chartName, "", tt.fields.opts...)
+			if err := g.Get(); (err != nil) != tt.wantErr {
+				t.Errorf("GetService.Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			files, err := os.ReadDir(dir)
+			if err != nil {
+				t.Errorf("reading destination: %s", err)
+			}
+			got := []string{}
+			for _, f := range files {
+				if strings.HasSuffix(f.Name(), ".tgz") {
+					got = append(got, f.Name())
+				}
+			}
+			if !reflect.DeepEqual(got, tt.wantTgz) {
+				t.Errorf("GetService.Get() charts = %v, want %v", got, tt.wantTgz)
+			}
+			index, err := repo.LoadIndexFile(path.Join(dir, indexFileName))
+			if err != nil {
+				t.Errorf("loading index file: %s", err)
+				return
+			}
+			indexed := []string{}
+			for _, chartVersions := range index.Entries {
+				for _, chartVersion := range chartVersions {
+					indexed = append(indexed, fmt.Sprintf("%s-%s.tgz", chartVersion.Name, chartVersion.Version))
+				}
+			}
+			sort.Strings(indexed)
+			if !reflect.DeepEqual(indexed, tt.wantTgz) {
+				t.Errorf("GetService.Get() index lists %v, want %v", indexed, tt.wantTgz)
+			}
+		})
+	}
+}
+
+func TestGetService_GetMerge(t *testing.T) {
+	svr := fixtures.NewTestRepositoryServer(t)
+	tests := []struct {
+		name  string
+		merge bool
+		want  []string
+	}{
+		{"1", false, []string{"chart2-1.0.1.tgz"}},
+		{"2", true, []string{"chart2-1.0.1.tgz", "old-0.1.0.tgz"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			existing := repo.NewIndexFile()
+			existing.Add(&chart.Metadata{Name: "old", Version: "0.1.0"}, "old-0.1.0.tgz", "", "")
+			existing.Add(&chart.Metadata{Name: "gone", Version: "0.1.0"}, "gone-0.1.0.tgz", "", "")
+			existing.Generated = existing.Generated.AddDate(-1, 0, 0)
+			if err := existing.WriteFile(path.Join(dir, indexFileName), 0o644); err != nil {
+				t.Errorf("writing index file: %s", err)
+			}
+			os.WriteFile(path.Join(dir, "old-0.1.0.tgz"), []byte("old"), 0o600)
+
+			var opts []GetOption
+			if tt.merge {
+				opts = append(opts, WithMerge())
+			}
+			g := NewGetService(repo.Entry{Name: dir, URL: svr.URL}, false, false, false, fakeLogger, "https://mirror.local.lan", "chart2", "", opts...)
+			if err := g.Get(); err != nil {
+				t.Errorf("GetService.Get() error = %v", err)
+				return
+			}
+
+			index, err := repo.LoadIndexFile(path.Join(dir, indexFileName))
+			if err != nil {
+				t.Errorf("loading index file: %s", err)
+				return
+			}
+			got := []string{}
+			for _, chartVersions := range index.Entries {
+				for _, chartVersion := range chartVersions {
+					filename := fmt.Sprintf("%s-%s.tgz", chartVersion.Name, chartVersion.Version)
+					got = append(got, filename)
+					if want := []string{"https://mirror.local.lan/" + filename}; !reflect.DeepEqual(chartVersion.URLs, want) {
+						t.Errorf("GetService.Get() URLs = %v, want %v", chartVersion.URLs, want)
+					}
+				}
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetService.Get() index lists %v, want %v", got, tt.want)
+			}
+			if !index.Generated.After(existing.Generated) {
+				t.Errorf("GetService.Get() generated = %v, want it updated", index.Generated)
+			}
 		})
 	}
 }
@@ -0,0 +1,347 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"k8s.io/helm/pkg/getter"
+	"k8s.io/helm/pkg/helm/environment"
+	"k8s.io/helm/pkg/version"
+)
+
+const (
+	defaultRetryWait      = time.Second
+	defaultConnectTimeout = 30 * time.Second
+	maxRetryWait          = 5 * time.Minute
+)
+
+// httpGetter fetches index files and charts over HTTP(S), retrying the
+// requests that fail with a transient error
+type httpGetter struct {
+	client    *http.Client
+	username  string
+	password  string
+	token     string
+	headers   http.Header
+	retries   int
+	retryWait time.Duration
+	logf      func(format string, args ...any)
+}
+
+// WithRetries retries the index file and chart downloads failing with a
+// timeout, a connection reset or refused, or a transient HTTP status (408,
+// 429, 500, 502, 503 or 504) up to retries times. Other failures, such as TLS
+// errors, are not retried. Retries are spaced by an exponential backoff starting
+// at wait, with jitter, unless the server asks for a delay with Retry-After.
+func WithRetries(retries int, wait time.Duration) GetOption {
+	return func(g *GetService) {
+		g.retries = retries
+		g.retryWait = wait
+	}
+}
+
+// WithTimeouts bounds the time spent establishing connections to the chart
+// repository to connect, and the time spent on each request, reading the
+// response included, to overall. Zero means no limit.
+func WithTimeouts(connect, overall time.Duration) GetOption {
+	return func(g *GetService) {
+		g.connectTimeout = connect
+		g.timeout = overall
+	}
+}
+
+// WithBearerToken authenticates the index file and chart requests with token,
+// sent as a bearer token instead of the username and password.
+func WithBearerToken(token string) GetOption {
+	return func(g *GetService) {
+		g.token = token
+	}
+}
+
+// WithHeaders adds headers to the index file and chart requests. They take
+// precedence over the headers set by the service, such as User-Agent.
+func WithHeaders(headers http.Header) GetOption {
+	return func(g *GetService) {
+		g.headers = headers
+	}
+}
+
+// WithProxy sends the requests to the chart repository through proxyURL
+// rather than the proxy of the HTTP_PROXY and HTTPS_PROXY environment
+// variables, and the requests to the hosts matched by noProxy, like NO_PROXY,
+// directly. An empty proxyURL keeps the proxy of the environment.
+func WithProxy(proxyURL string, noProxy []string) GetOption {
+	return func(g *GetService) {
+		g.proxyURL = proxyURL
+		g.noProxy = noProxy
+	}
+}
+
+// setProxy makes transport use the proxy configured for the service, if any.
+func (g *GetService) setProxy(transport *http.Transport) error {
+	if g.proxyURL == "" && len(g.noProxy) == 0 {
+		return nil
+	}
+
+	proxy, err := proxyFunc(g.proxyURL, g.noProxy)
+	if err != nil {
+		return err
+	}
+	transport.Proxy = proxy
+
+	return nil
+}
+
+// getters returns the getter providers used to reach the chart repository:
+// HTTP(S) is handled by httpGetter, file:// by fileGetter, other schemes by
+// the Helm plugins.
+func (g *GetService) getters() getter.Providers {
+	return append(getter.Providers{{
+		Schemes: []string{"http", "https"},
+		New: func(serverURL, _, _, _ string) (getter.Getter, error) {
+			return g.newHTTPGetter(serverURL)
+		},
+	}, {
+		Schemes: []string{fileScheme},
+		New: func(_, _, _, _ string) (getter.Getter, error) {
+			return fileGetter{}, nil
+		},
+	}}, getter.All(environment.EnvSettings{})...)
+}
+
+// newHTTPGetter returns the getter fetching files from serverURL with the
+// credentials, headers, TLS files, proxy, retries and timeouts of the service.
+func (g *GetService) newHTTPGetter(serverURL string) (*httpGetter, error) {
+	transport, err := newTransport(g.config, serverURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := g.setProxy(transport); err != nil {
+		return nil, err
+	}
+	transport.DisableCompression = true
+	if g.connectTimeout > 0 {
+		transport.DialContext = (&net.Dialer{Timeout: g.connectTimeout}).DialContext
+		transport.TLSHandshakeTimeout = g.connectTimeout
+	}
+
+	retryWait := g.retryWait
+	if retryWait <= 0 {
+		retryWait = defaultRetryWait
+	}
+
+	return &httpGetter{
+		client:    &http.Client{Transport: g.limitTransport(transport), Timeout: g.timeout},
+		username:  g.config.Username,
+		password:  g.config.Password,
+		token:     g.token,
+		headers:   g.headers,
+		retries:   g.retries,
+		retryWait: retryWait,
+		logf:      g.logVerbose,
+	}, nil
+}
+
+// Get fetches href, retrying transient failures.
+func (h *httpGetter) Get(href string) (*bytes.Buffer, error) {
+	var buf *bytes.Buffer
+	err := h.retry(href, func() (time.Duration, error) {
+		var wait time.Duration
+		var err error
+		buf, wait, err = h.get(href)
+		return wait, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+// retry calls attempt until it succeeds, fails permanently or the retries
+// run out. On failure, attempt returns how long to wait before retrying: zero
+// to use the backoff, negative when the failure is permanent.
+func (h *httpGetter) retry(href string, attempt func() (time.Duration, error)) error {
+	for i := 0; ; i++ {
+		wait, err := attempt()
+		if err == nil {
+			return nil
+		}
+
+		if wait < 0 || i >= h.retries {
+			return err
+		}
+
+		if wait == 0 {
+			wait = backoff(h.retryWait, i)
+		}
+		h.logf("Retrying %s in %s (%d/%d): %s", href, wait, i+1, h.retries, err)
+		time.Sleep(wait)
+	}
+}
+
+// get fetches href once. On failure, it also returns how long to wait before
+// retrying: zero to use the backoff, negative when the failure is permanent.
+func (h *httpGetter) get(href string) (*bytes.Buffer, time.Duration, error) {
+	req, err := h.newRequest(http.MethodGet, href)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, errorWait(err), fmt.Errorf("cannot fetch %s: %w", href, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusWait(resp), fmt.Errorf("cannot fetch %s: %w", href, &statusError{code: resp.StatusCode, status: resp.Status})
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if _, err := io.Copy(buf, resp.Body); err != nil {
+		return nil, errorWait(err), fmt.Errorf("cannot read %s: %w", href, err)
+	}
+
+	return buf, 0, nil
+}
+
+// newRequest returns a request to href carrying the credentials and headers
+// of the getter.
+func (h *httpGetter) newRequest(method string, href string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(context.Background(), method, href, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create request: %w", err)
+	}
+	h.setHeaders(req)
+
+	return req, nil
+}
+
+// setHeaders sets the credentials and headers of the getter on req.
+func (h *httpGetter) setHeaders(req *http.Request) {
+	req.Header.Set("User-Agent", "Helm/"+strings.TrimPrefix(version.GetVersion(), "v"))
+
+	switch {
+	case h.token != "":
+		req.Header.Set("Authorization", "Bearer "+h.token)
+	case h.username != "" && h.password != "":
+		req.SetBasicAuth(h.username, h.password)
+	}
+
+	for name, values := range h.headers {
+		req.Header[http.CanonicalHeaderKey(name)] = values
+	}
+}
+
+// statusError is the error of a request answered with an HTTP status other
+// than 200 OK.
+type statusError struct {
+	code   int
+	status string
+}
+
+func (e *statusError) Error() string {
+	return e.status
+}
+
+// statusWait returns how long to wait before retrying a request answered
+// with resp, as returned to retry.
+func statusWait(resp *http.Response) time.Duration {
+	if !retryableStatus(resp.StatusCode) {
+		return -1
+	}
+
+	return retryAfter(resp.Header.Get("Retry-After"))
+}
+
+// errorWait returns how long to wait before retrying a request failing with
+// err, as returned to retry: the backoff for timeouts and connections reset,
+// refused or closed early, a permanent failure otherwise, such as a TLS
+// error, an invalid URL or a cancelled request.
+func errorWait(err error) time.Duration {
+	var status *statusError
+	if errors.As(err, &status) {
+		if retryableStatus(status.code) {
+			return 0
+		}
+		return -1
+	}
+
+	if errors.Is(err, context.Canceled) {
+		return -1
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return 0
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsTemporary {
+		return 0
+	}
+
+	switch {
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.ECONNABORTED),
+		errors.Is(err, syscall.EPIPE), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return 0
+	default:
+		return -1
+	}
+}
+
+func retryableStatus(status int) bool {
+	switch status {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusInternalServerError,
+		http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// retryAfter parses a Retry-After header, given in seconds or as an HTTP
+// date, into a delay capped to maxRetryWait. Missing or invalid values give
+// zero.
+func retryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	var wait time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil {
+		wait = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(value); err == nil {
+		wait = time.Until(date)
+	}
+
+	if wait <= 0 {
+		return 0
+	}
+
+	return min(wait, maxRetryWait)
+}
+
+// backoff returns the delay before retry number attempt+1: wait doubled for
+// each previous attempt, capped to maxRetryWait, with its upper half
+// randomized so clients do not retry in lockstep.
+func backoff(wait time.Duration, attempt int) time.Duration {
+	delay := wait << min(attempt, 30)
+	if delay <= 0 || delay > maxRetryWait {
+		delay = maxRetryWait
+	}
+
+	half := delay / 2
+	return half + rand.N(half+1) //nolint:gosec // jitter does not need a secure source
+}
@@ -0,0 +1,307 @@
+package service
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/konstructio/helm-mirror/fixtures"
+	"k8s.io/helm/pkg/repo"
+)
+
+func Test_httpGetter_Get(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		failures     int32
+		retries      int
+		delay        time.Duration
+		timeout      time.Duration
+		wantErr      bool
+		wantRequests int32
+	}{
+		{"1", http.StatusBadGateway, 2, 2, 0, 0, false, 3},
+		{"2", http.StatusBadGateway, 3, 2, 0, 0, true, 3},
+		{"3", http.StatusTooManyRequests, 1, 1, 0, 0, false, 2},
+		{"4", http.StatusNotFound, 1, 3, 0, 0, true, 1},
+		{"5", http.StatusServiceUnavailable, 1, 0, 0, 0, true, 1},
+		{"6", http.StatusOK, 0, 1, 50 * time.Millisecond, 10 * time.Millisecond, true, 2},
+		{"7", http.StatusOK, 0, 0, 0, time.Second, false, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if requests.Add(1) <= tt.failures {
+					w.WriteHeader(tt.status)
+					return
+				}
+				time.Sleep(tt.delay)
+				w.Write([]byte("content"))
+			}))
+			defer svr.Close()
+
+			g := &GetService{config: repo.Entry{URL: svr.URL}, logger: fakeLogger, retries: tt.retries, retryWait: time.Millisecond, timeout: tt.timeout}
+			client, err := g.newHTTPGetter(svr.URL)
+			if err != nil {
+				t.Errorf("newHTTPGetter() error = %v", err)
+				return
+			}
+
+			got, err := client.Get(svr.URL + "/index.yaml")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("httpGetter.Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got.String() != "content" {
+				t.Errorf("httpGetter.Get() = %q, want %q", got, "content")
+			}
+			if requests.Load() != tt.wantRequests {
+				t.Errorf("httpGetter.Get() sent %d requests, want %d", requests.Load(), tt.wantRequests)
+			}
+		})
+	}
+}
+
+func Test_httpGetter_GetUntrustedCertificate(t *testing.T) {
+	var requests atomic.Int32
+	svr := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests.Add(1)
+	}))
+	defer svr.Close()
+
+	var retries atomic.Int32
+	client := &httpGetter{client: &http.Client{}, retries: 3, retryWait: time.Millisecond, logf: func(string, ...any) { retries.Add(1) }}
+	if _, err := client.Get(svr.URL + "/index.yaml"); err == nil {
+		t.Errorf("httpGetter.Get() error = nil, want a certificate error")
+	}
+	if requests.Load() != 0 || retries.Load() != 0 {
+		t.Errorf("httpGetter.Get() served %d requests and retried %d times, want none", requests.Load(), retries.Load())
+	}
+}
+
+func Test_errorWait(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want time.Duration
+	}{
+		{"1", fmt.Errorf("cannot fetch: %w", &statusError{code: http.StatusServiceUnavailable, status: "503 Service Unavailable"}), 0},
+		{"2", fmt.Errorf("cannot fetch: %w", &statusError{code: http.StatusNotFound, status: "404 Not Found"}), -1},
+		{"3", &url.Error{Op: "Get", URL: "https://example.com", Err: &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}}, 0},
+		{"4", &url.Error{Op: "Get", URL: "https://example.com", Err: &net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}}, 0},
+		{"5", &url.Error{Op: "Get", URL: "https://example.com", Err: context.DeadlineExceeded}, 0},
+		{"6", &url.Error{Op: "Get", URL: "https://example.com", Err: io.EOF}, 0},
+		{"7", &url.Error{Op: "Get", URL: "https://example.com", Err: &net.DNSError{Err: "server misbehaving", Name: "example.com", IsTemporary: true}}, 0},
+		{"8", &url.Error{Op: "Get", URL: "https://example.com", Err: &net.DNSError{Err: "no such host", Name: "example.com", IsNotFound: true}}, -1},
+		{"9", &url.Error{Op: "Get", URL: "https://example.com", Err: x509.UnknownAuthorityError{}}, -1},
+		{"10", &url.Error{Op: "Get", URL: "https://example.com", Err: context.Canceled}, -1},
+		{"11", &url.Error{Op: "Get", URL: "ftp://example.com", Err: errors.New("unsupported protocol scheme \"ftp\"")}, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errorWait(tt.err); got != tt.want {
+				t.Errorf("errorWait() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_isNotFound(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"1", fmt.Errorf("cannot fetch: %w", &statusError{code: http.StatusNotFound, status: "404 Not Found"}), true},
+		{"2", fmt.Errorf("cannot fetch: %w", &statusError{code: http.StatusForbidden, status: "403 Forbidden"}), false},
+		{"3", fmt.Errorf("cannot read: %w", os.ErrNotExist), true},
+		{"4", errors.New("connection refused"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isNotFound(tt.err); got != tt.want {
+				t.Errorf("isNotFound() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_retryAfter(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		min   time.Duration
+		max   time.Duration
+	}{
+		{"1", "", 0, 0},
+		{"2", "3", 3 * time.Second, 3 * time.Second},
+		{"3", "-1", 0, 0},
+		{"4", "soon", 0, 0},
+		{"5", "86400", maxRetryWait, maxRetryWait},
+		{"6", time.Now().Add(time.Minute).UTC().Format(http.TimeFormat), 50 * time.Second, time.Minute},
+		{"7", time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryAfter(tt.value); got < tt.min || got > tt.max {
+				t.Errorf("retryAfter() = %v, want between %v and %v", got, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func Test_backoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		wait    time.Duration
+		attempt int
+		min     time.Duration
+		max     time.Duration
+	}{
+		{"1", time.Second, 0, 500 * time.Millisecond, time.Second},
+		{"2", time.Second, 3, 4 * time.Second, 8 * time.Second},
+		{"3", time.Second, 20, maxRetryWait / 2, maxRetryWait},
+		{"4", time.Second, 100, maxRetryWait / 2, maxRetryWait},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for range 10 {
+				if got := backoff(tt.wait, tt.attempt); got < tt.min || got > tt.max {
+					t.Errorf("backoff() = %v, want between %v and %v", got, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
+
+func TestGetService_GetFlakyRepository(t *testing.T) {
+	tests := []struct {
+		name    string
+		retries int
+		wantErr bool
+	}{
+		{"1", 0, true},
+		{"2", 2, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svr := fixtures.NewFlakyRepositoryServer(2)
+			defer svr.Close()
+			dir, err := os.MkdirTemp("", "helmmirrortests")
+			if err != nil {
+				t.Errorf("Creating tmp directory: %s", err)
+			}
+			defer os.RemoveAll(dir)
+
+			g := NewGetService(repo.Entry{Name: dir, URL: svr.URL}, false, false, false, fakeLogger, "", "chart2", "", WithRetries(tt.retries, time.Millisecond), WithTimeouts(time.Second, 5*time.Second))
+			err = g.Get()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetService.Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), "503") {
+				t.Errorf("GetService.Get() error = %v, want a 503", err)
+			}
+			if _, err := os.Stat(dir + "/chart2-1.0.1.tgz"); (err != nil) != tt.wantErr {
+				t.Errorf("GetService.Get() chart downloaded = %v, want %v", err == nil, !tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_httpGetter_newRequest(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+		password string
+		opts     []GetOption
+		want     http.Header
+	}{
+		{"1", "user", "pass", nil, http.Header{"Authorization": {"Basic dXNlcjpwYXNz"}}},
+		{"2", "user", "pass", []GetOption{WithBearerToken("token")}, http.Header{"Authorization": {"Bearer token"}}},
+		{"3", "", "", []GetOption{WithHeaders(http.Header{"X-Api-Key": {"key"}, "User-Agent": {"mirror"}})}, http.Header{"X-Api-Key": {"key"}, "User-Agent": {"mirror"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &GetService{config: repo.Entry{Username: tt.username, Password: tt.password}, logger: fakeLogger}
+			for _, opt := range tt.opts {
+				opt(g)
+			}
+			client, err := g.newHTTPGetter("https://charts.example.com")
+			if err != nil {
+				t.Errorf("newHTTPGetter() error = %v", err)
+				return
+			}
+
+			req, err := client.newRequest(http.MethodGet, "https://charts.example.com/index.yaml")
+			if err != nil {
+				t.Errorf("httpGetter.newRequest() error = %v", err)
+				return
+			}
+			for name, values := range tt.want {
+				if got := req.Header.Values(name); !reflect.DeepEqual(got, values) {
+					t.Errorf("httpGetter.newRequest() header %s = %v, want %v", name, got, values)
+				}
+			}
+		})
+	}
+}
+
+func TestGetService_GetThroughProxy(t *testing.T) {
+	var proxied atomic.Int32
+	repository := fixtures.NewRepositoryServer()
+	defer repository.Close()
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied.Add(1)
+		r.URL.Host = strings.TrimPrefix(repository.URL, "http://")
+		resp, err := http.DefaultTransport.RoundTrip(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+	}))
+	defer proxy.Close()
+
+	tests := []struct {
+		name        string
+		noProxy     []string
+		wantProxied bool
+	}{
+		{"1", nil, true},
+		{"2", []string{"charts.invalid"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxied.Store(0)
+			dir, err := os.MkdirTemp("", "helmmirrortests")
+			if err != nil {
+				t.Errorf("Creating tmp directory: %s", err)
+			}
+			defer os.RemoveAll(dir)
+
+			g := NewGetService(repo.Entry{Name: dir, URL: "http://charts.invalid"}, false, false, false, fakeLogger, "", "chart2", "", WithProxy(proxy.URL, tt.noProxy), WithTimeouts(time.Second, 5*time.Second))
+			err = g.Get()
+			if (err != nil) == tt.wantProxied {
+				t.Errorf("GetService.Get() error = %v, want it to succeed only through the proxy", err)
+			}
+			if (proxied.Load() > 0) != tt.wantProxied {
+				t.Errorf("GetService.Get() sent %d requests through the proxy, want proxied %v", proxied.Load(), tt.wantProxied)
+			}
+		})
+	}
+}
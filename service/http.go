@@ -0,0 +1,114 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"k8s.io/helm/pkg/repo"
+	"k8s.io/helm/pkg/tlsutil"
+)
+
+// newTransport returns an HTTP transport honouring the proxy environment
+// variables and the TLS files of config, used to reach serverURL.
+func newTransport(config repo.Entry, serverURL string) (*http.Transport, error) {
+	transport := &http.Transport{Proxy: http.ProxyFromEnvironment}
+	if (config.CertFile != "" && config.KeyFile != "") || config.CAFile != "" {
+		tlsConf, err := tlsutil.NewTLSConfig(serverURL, config.CertFile, config.KeyFile, config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create TLS config: %w", err)
+		}
+		transport.TLSClientConfig = tlsConf
+	}
+
+	return transport, nil
+}
+
+// proxyFunc returns the proxy function of a transport sending requests through
+// proxyURL, or through the proxy of the environment variables when it is
+// empty, except the requests to the hosts matched by noProxy.
+func proxyFunc(proxyURL string, noProxy []string) (func(*http.Request) (*url.URL, error), error) {
+	proxy := http.ProxyFromEnvironment
+	if proxyURL != "" {
+		fixed, err := url.Parse(proxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL %q: %w", proxyURL, err)
+		}
+		if fixed.Host == "" || (fixed.Scheme != "http" && fixed.Scheme != "https" && fixed.Scheme != "socks5") {
+			return nil, fmt.Errorf("invalid proxy URL %q: expected http(s)://host[:port]", proxyURL)
+		}
+		proxy = http.ProxyURL(fixed)
+	}
+
+	if len(noProxy) == 0 {
+		return proxy, nil
+	}
+
+	return func(req *http.Request) (*url.URL, error) {
+		if bypassProxy(noProxy, req.URL) {
+			return nil, nil //nolint:nilnil // no proxy for this request
+		}
+		return proxy(req)
+	}, nil
+}
+
+// bypassProxy reports whether target matches one of the noProxy entries, like
+// the NO_PROXY environment variable: "*" matches every host, an IP address or
+// CIDR range matches the addresses it covers, "example.com" matches the domain
+// and its subdomains and ".example.com" only the subdomains. Entries other
+// than CIDR ranges may restrict the match to a port.
+func bypassProxy(noProxy []string, target *url.URL) bool {
+	host := strings.ToLower(target.Hostname())
+	port := target.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443"}[target.Scheme]
+	}
+	ip := net.ParseIP(host)
+
+	for _, entry := range noProxy {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		switch {
+		case entry == "":
+			continue
+		case entry == "*":
+			return true
+		}
+
+		if _, cidr, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+
+		entryHost := entry
+		if h, p, err := net.SplitHostPort(entry); err == nil {
+			if p != port {
+				continue
+			}
+			entryHost = h
+		}
+
+		if entryIP := net.ParseIP(entryHost); entryIP != nil {
+			if ip != nil && ip.Equal(entryIP) {
+				return true
+			}
+			continue
+		}
+
+		entryHost = strings.TrimPrefix(entryHost, "*")
+		if domain, ok := strings.CutPrefix(entryHost, "."); ok {
+			if strings.HasSuffix(host, "."+domain) {
+				return true
+			}
+			continue
+		}
+		if host == entryHost || strings.HasSuffix(host, "."+entryHost) {
+			return true
+		}
+	}
+
+	return false
+}
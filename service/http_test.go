@@ -0,0 +1,78 @@
+package service
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func Test_bypassProxy(t *testing.T) {
+	tests := []struct {
+		name    string
+		noProxy []string
+		target  string
+		want    bool
+	}{
+		{"1", nil, "https://charts.example.com", false},
+		{"2", []string{"*"}, "https://charts.example.com", true},
+		{"3", []string{"example.com"}, "https://charts.example.com", true},
+		{"4", []string{"example.com"}, "https://example.com/charts", true},
+		{"5", []string{"example.com"}, "https://badexample.com", false},
+		{"6", []string{".example.com"}, "https://example.com", false},
+		{"7", []string{".example.com"}, "https://charts.example.com", true},
+		{"8", []string{"*.example.com"}, "https://charts.example.com", true},
+		{"9", []string{"10.0.0.0/8"}, "http://10.1.2.3:8080", true},
+		{"10", []string{"10.0.0.0/8"}, "http://192.168.1.1", false},
+		{"11", []string{"192.168.1.1"}, "http://192.168.1.1", true},
+		{"12", []string{"example.com:8443"}, "https://example.com", false},
+		{"13", []string{"example.com:443"}, "https://example.com", true},
+		{"14", []string{"", " EXAMPLE.com "}, "https://Charts.Example.com", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, _ := url.Parse(tt.target)
+			if got := bypassProxy(tt.noProxy, target); got != tt.want {
+				t.Errorf("bypassProxy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_proxyFunc(t *testing.T) {
+	tests := []struct {
+		name     string
+		proxyURL string
+		noProxy  []string
+		target   string
+		want     string
+		wantErr  bool
+	}{
+		{"1", "http://proxy.local.lan:3128", nil, "https://charts.example.com", "http://proxy.local.lan:3128", false},
+		{"2", "http://proxy.local.lan:3128", []string{"example.com"}, "https://charts.example.com", "", false},
+		{"3", "http://proxy.local.lan:3128", []string{"example.com"}, "https://charts.example.org", "http://proxy.local.lan:3128", false},
+		{"4", "proxy.local.lan:3128", nil, "", "", true},
+		{"5", "ftp://proxy.local.lan", nil, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy, err := proxyFunc(tt.proxyURL, tt.noProxy)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("proxyFunc() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+
+			req, _ := http.NewRequest(http.MethodGet, tt.target, nil)
+			got, err := proxy(req)
+			if err != nil {
+				t.Errorf("proxy() error = %v", err)
+				return
+			}
+			if (got == nil && tt.want != "") || (got != nil && got.String() != tt.want) {
+				t.Errorf("proxy() = %v, want %q", got, tt.want)
+			}
+		})
+	}
+}
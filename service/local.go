@@ -0,0 +1,115 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// fileScheme is the URL scheme of the chart repositories read from disk
+const fileScheme = "file"
+
+// fileGetter reads index files and charts from disk, for the file:// chart
+// repositories
+type fileGetter struct{}
+
+// Get reads the file at the file:// URL href.
+func (fileGetter) Get(href string) (*bytes.Buffer, error) {
+	name, err := localPath(href)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %q: %w", name, err)
+	}
+
+	return bytes.NewBuffer(content), nil
+}
+
+// localPath returns the path of the file at the file:// URL href.
+func localPath(href string) (string, error) {
+	u, err := url.Parse(href)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL %q: %w", href, err)
+	}
+	if u.Scheme != fileScheme {
+		return "", fmt.Errorf("not a file:// URL: %q", href)
+	}
+	if u.Host != "" && u.Host != "localhost" {
+		return "", fmt.Errorf("cannot read %q from another host", href)
+	}
+
+	return filepath.FromSlash(u.Path), nil
+}
+
+// localSource returns the absolute path of the folder of a chart repository
+// given as a file:// URL or as a directory path, and false for the other
+// repositories.
+func localSource(source string) (string, bool) {
+	if source == "" {
+		return "", false
+	}
+
+	u, err := url.Parse(source)
+	switch {
+	case err != nil || u.Scheme == "":
+		folder, err := filepath.Abs(source)
+		return folder, err == nil
+	case u.Scheme == fileScheme:
+		folder, err := localPath(source)
+		return folder, err == nil
+	default:
+		return "", false
+	}
+}
+
+// useLocalSource points the configuration of a chart repository read from disk
+// to the file:// URL of its folder, and checks it is not mirrored into itself.
+func (g *GetService) useLocalSource() error {
+	folder, ok := localSource(g.config.URL)
+	if !ok {
+		return nil
+	}
+
+	destination, err := filepath.Abs(g.config.Name)
+	if err != nil {
+		return fmt.Errorf("cannot resolve destination folder: %w", err)
+	}
+	if filepath.Clean(folder) == destination {
+		return errors.New("cannot mirror a local repository into its own folder")
+	}
+
+	g.local = true
+	g.config.URL = (&url.URL{Scheme: fileScheme, Path: filepath.ToSlash(folder)}).String()
+
+	return nil
+}
+
+// localChartURL returns the file:// URL of the chart named like the file at
+// the remote chartURL in the folder of the repository read from disk, when
+// there is one. It lets copies of mirrors whose index file points to another
+// server be mirrored again without reaching it.
+func (g *GetService) localChartURL(chartURL *url.URL) (string, bool) {
+	if !g.local || chartURL.Scheme == fileScheme {
+		return "", false
+	}
+
+	base := path.Base(chartURL.Path)
+	if base == "." || base == dirSeparator {
+		return "", false
+	}
+
+	candidate := g.config.URL + dirSeparator + url.PathEscape(base)
+	name, err := localPath(candidate)
+	if err != nil || !fileExists(name) {
+		return "", false
+	}
+
+	return candidate, true
+}
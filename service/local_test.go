@@ -0,0 +1,118 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/url"
+	"os"
+	"path"
+	"testing"
+
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/repo"
+)
+
+func Test_localSource(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("getting working directory: %s", err)
+	}
+
+	tests := []struct {
+		name   string
+		source string
+		want   string
+		wantOk bool
+	}{
+		{"1", "/mnt/charts", "/mnt/charts", true},
+		{"2", "./charts", path.Join(wd, "charts"), true},
+		{"3", "file:///mnt/usb%20drive/charts", "/mnt/usb drive/charts", true},
+		{"4", "file://localhost/mnt/charts", "/mnt/charts", true},
+		{"5", "file://server/mnt/charts", "", false},
+		{"6", "https://charts.example.com", "", false},
+		{"7", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := localSource(tt.source)
+			if ok != tt.wantOk || (ok && got != tt.want) {
+				t.Errorf("localSource() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestGetService_GetLocal(t *testing.T) {
+	dir, err := os.MkdirTemp("", "helmmirrortests")
+	if err != nil {
+		t.Errorf("Creating tmp directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// chart1 is listed relative to the index file, chart2 on a server that
+	// cannot be reached but next to the index file too
+	source := path.Join(dir, "usb drive")
+	if err := os.Mkdir(source, 0o755); err != nil {
+		t.Errorf("creating source folder: %s", err)
+	}
+	index := repo.NewIndexFile()
+	for name, baseURL := range map[string]string{"chart1": "", "chart2": "https://charts.invalid/charts"} {
+		content := chartArchive(t, map[string]string{name + "/Chart.yaml": "name: " + name + "\nversion: 1.0.0\n"})
+		if err := os.WriteFile(path.Join(source, name+"-1.0.0.tgz"), content, 0o600); err != nil {
+			t.Errorf("writing chart: %s", err)
+		}
+		sum := sha256.Sum256(content)
+		index.Add(&chart.Metadata{Name: name, Version: "1.0.0"}, name+"-1.0.0.tgz", baseURL, hex.EncodeToString(sum[:]))
+	}
+	index.SortEntries()
+	content, _ := json.Marshal(index)
+	if err := os.WriteFile(path.Join(source, indexFileName), content, 0o600); err != nil {
+		t.Errorf("writing index file: %s", err)
+	}
+
+	tests := []struct {
+		name    string
+		source  string
+		folder  string
+		wantErr bool
+	}{
+		{"1", source, "path", false},
+		{"2", (&url.URL{Scheme: fileScheme, Path: source}).String(), "url", false},
+		{"3", source, "", true},
+		{"4", path.Join(dir, "missing"), "missing", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			folder := source
+			if tt.folder != "" {
+				folder = path.Join(dir, tt.folder)
+				if err := os.Mkdir(folder, 0o755); err != nil {
+					t.Errorf("creating mirror folder: %s", err)
+				}
+			}
+
+			g := NewGetService(repo.Entry{Name: folder, URL: tt.source}, true, false, false, fakeLogger, "", "", "")
+			if err := g.Get(); (err != nil) != tt.wantErr {
+				t.Errorf("GetService.Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			for _, name := range []string{"chart1-1.0.0.tgz", "chart2-1.0.0.tgz"} {
+				if _, err := os.Stat(path.Join(folder, name)); err != nil {
+					t.Errorf("GetService.Get() did not mirror %s: %s", name, err)
+				}
+			}
+			mirrored, err := repo.LoadIndexFile(path.Join(folder, indexFileName))
+			if err != nil {
+				t.Errorf("loading mirrored index file: %s", err)
+				return
+			}
+			if got, want := mirrored.Entries["chart2"][0].URLs[0], "chart2-1.0.0.tgz"; got != want {
+				t.Errorf("GetService.Get() chart2 URL = %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,301 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v3"
+	"k8s.io/helm/pkg/repo"
+)
+
+// Manifest lists the chart repositories mirrored by a ManifestService
+type Manifest struct {
+	Repositories []ManifestRepository `yaml:"repositories"`
+}
+
+// ManifestRepository describes a chart repository to mirror, how to connect
+// to it and which of its charts to mirror
+type ManifestRepository struct {
+	// Name identifies the repository in logs and is the default folder
+	Name string `yaml:"name"`
+	// URL of the chart repository, http(s)://, oci:// or file://, or the path
+	// of a folder holding one
+	URL string `yaml:"url"`
+	// Folder the charts are mirrored to, relative to the destination folder
+	Folder             string            `yaml:"folder,omitempty"`
+	Username           string            `yaml:"username,omitempty"`
+	Password           string            `yaml:"password,omitempty"`
+	Token              string            `yaml:"token,omitempty"`
+	Headers            map[string]string `yaml:"headers,omitempty"`
+	Proxy              string            `yaml:"proxy,omitempty"`
+	NoProxy            []string          `yaml:"noProxy,omitempty"`
+	CAFile             string            `yaml:"caFile,omitempty"`
+	CertFile           string            `yaml:"certFile,omitempty"`
+	KeyFile            string            `yaml:"keyFile,omitempty"`
+	NewRootURL         string            `yaml:"newRootURL,omitempty"`
+	Charts             []string          `yaml:"charts,omitempty"`
+	Include            []string          `yaml:"include,omitempty"`
+	Exclude            []string          `yaml:"exclude,omitempty"`
+	ChartVersion       string            `yaml:"chartVersion,omitempty"`
+	AllVersions        bool              `yaml:"allVersions,omitempty"`
+	VersionConstraint  string            `yaml:"versionConstraint,omitempty"`
+	Latest             int               `yaml:"latest,omitempty"`
+	ExcludePrereleases bool              `yaml:"excludePrereleases,omitempty"`
+	Provenance         bool              `yaml:"provenance,omitempty"`
+	Keyring            string            `yaml:"keyring,omitempty"`
+	PlainHTTP          bool              `yaml:"plainHTTP,omitempty"`
+	Concurrency        int               `yaml:"concurrency,omitempty"`
+	Merge              bool              `yaml:"merge,omitempty"`
+	Prune              bool              `yaml:"prune,omitempty"`
+	Dependencies       bool              `yaml:"dependencies,omitempty"`
+	Retries            int               `yaml:"retries,omitempty"`
+	RetryWait          time.Duration     `yaml:"retryWait,omitempty"`
+	ConnectTimeout     time.Duration     `yaml:"connectTimeout,omitempty"`
+	Timeout            time.Duration     `yaml:"timeout,omitempty"`
+	MaxRate            int64             `yaml:"maxRate,omitempty"`
+	MaxRequests        float64           `yaml:"maxRequestsPerSecond,omitempty"`
+}
+
+// LoadManifest reads and validates the manifest at name. Relative TLS,
+// keyring and repository folder paths are resolved against the folder holding
+// the manifest, and environment variables referenced in usernames, passwords,
+// tokens and header values ($VAR or ${VAR}) are expanded so credentials do not
+// have to be committed with it.
+func LoadManifest(name string) (*Manifest, error) {
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read manifest: %w", err)
+	}
+
+	manifest := &Manifest{}
+	if err := yaml.Unmarshal(content, manifest); err != nil {
+		return nil, fmt.Errorf("cannot parse manifest %q: %w", name, err)
+	}
+
+	base := filepath.Dir(name)
+	for i := range manifest.Repositories {
+		repository := &manifest.Repositories[i]
+		repository.Username = os.ExpandEnv(repository.Username)
+		repository.Password = os.ExpandEnv(repository.Password)
+		repository.Token = os.ExpandEnv(repository.Token)
+		for name, value := range repository.Headers {
+			repository.Headers[name] = os.ExpandEnv(value)
+		}
+		for _, file := range []*string{&repository.CAFile, &repository.CertFile, &repository.KeyFile, &repository.Keyring} {
+			if *file != "" && !filepath.IsAbs(*file) {
+				*file = filepath.Join(base, *file)
+			}
+		}
+		if repoURL, err := url.Parse(repository.URL); err == nil && repoURL.Scheme == "" && repository.URL != "" && !filepath.IsAbs(repository.URL) {
+			repository.URL = filepath.Join(base, repository.URL)
+		}
+	}
+
+	if err := manifest.validate(); err != nil {
+		return nil, fmt.Errorf("invalid manifest %q: %w", name, err)
+	}
+
+	return manifest, nil
+}
+
+// validate checks that every repository can be mirrored and that no two
+// repositories share a folder.
+func (m *Manifest) validate() error {
+	if len(m.Repositories) == 0 {
+		return errors.New("no repositories listed")
+	}
+
+	folders := map[string]string{}
+	for _, repository := range m.Repositories {
+		if repository.Name == "" {
+			return fmt.Errorf("repository %q has no name", repository.URL)
+		}
+
+		repoURL, err := url.Parse(repository.URL)
+		if err != nil {
+			return fmt.Errorf("repository %q: invalid URL %q: %w", repository.Name, repository.URL, err)
+		}
+		if !strings.Contains(repoURL.Scheme, "http") && repoURL.Scheme != ociScheme && repoURL.Scheme != fileScheme && repoURL.Scheme != "" {
+			return fmt.Errorf("repository %q: not a valid URL protocol: %q", repository.Name, repoURL.Scheme)
+		}
+		if repoURL.Scheme == ociScheme && len(repository.Charts) == 0 {
+			return fmt.Errorf("repository %q: charts must be listed for oci:// repositories", repository.Name)
+		}
+		if repoURL.Scheme == ociScheme && (repository.Provenance || repository.Keyring != "") {
+			return fmt.Errorf("repository %q: provenance and keyring cannot be used with oci:// repositories", repository.Name)
+		}
+
+		if repository.Merge && repository.Prune {
+			return fmt.Errorf("repository %q: merge and prune cannot be used together", repository.Name)
+		}
+
+		if repository.ChartVersion != "" && len(repository.Charts) != 1 {
+			return fmt.Errorf("repository %q: chartVersion needs exactly one chart", repository.Name)
+		}
+
+		folder := repository.folder()
+		if folder == "." || path.IsAbs(folder) || strings.HasPrefix(folder, "../") || folder == ".." {
+			return fmt.Errorf("repository %q: folder %q must be a subfolder of the destination", repository.Name, repository.Folder)
+		}
+		if other, ok := folders[folder]; ok {
+			return fmt.Errorf("repositories %q and %q are mirrored to the same folder %q", other, repository.Name, folder)
+		}
+		folders[folder] = repository.Name
+	}
+
+	return nil
+}
+
+// folder returns the cleaned folder of the repository, relative to the
+// destination folder.
+func (r *ManifestRepository) folder() string {
+	if r.Folder == "" {
+		return path.Clean(r.Name)
+	}
+
+	return path.Clean(r.Folder)
+}
+
+// getService builds the GetService mirroring the repository into its folder
+// under destination.
+func (r *ManifestRepository) getService(destination string, verbose bool, ignoreErrors bool, logger *log.Logger) *GetService {
+	config := repo.Entry{
+		Name:     path.Join(destination, r.folder()),
+		URL:      r.URL,
+		Username: r.Username,
+		Password: r.Password,
+		CAFile:   r.CAFile,
+		CertFile: r.CertFile,
+		KeyFile:  r.KeyFile,
+	}
+
+	concurrency := r.Concurrency
+	if concurrency == 0 {
+		concurrency = 1
+	}
+
+	connectTimeout := r.ConnectTimeout
+	if connectTimeout == 0 {
+		connectTimeout = defaultConnectTimeout
+	}
+
+	opts := []GetOption{
+		WithConcurrency(concurrency),
+		WithRetries(r.Retries, r.RetryWait),
+		WithTimeouts(connectTimeout, r.Timeout),
+		WithRateLimits(r.MaxRate, r.MaxRequests),
+	}
+	if r.Token != "" {
+		opts = append(opts, WithBearerToken(r.Token))
+	}
+	if len(r.Headers) > 0 {
+		headers := http.Header{}
+		for name, value := range r.Headers {
+			headers.Set(name, value)
+		}
+		opts = append(opts, WithHeaders(headers))
+	}
+	if r.Proxy != "" || len(r.NoProxy) > 0 {
+		opts = append(opts, WithProxy(r.Proxy, r.NoProxy))
+	}
+	if r.Provenance || r.Keyring != "" {
+		opts = append(opts, WithProvenance(r.Keyring))
+	}
+	if r.VersionConstraint != "" {
+		opts = append(opts, WithVersionConstraint(r.VersionConstraint))
+	}
+	if len(r.Include) > 0 {
+		opts = append(opts, WithIncludes(r.Include))
+	}
+	if len(r.Exclude) > 0 {
+		opts = append(opts, WithExcludes(r.Exclude))
+	}
+	if r.Latest > 0 {
+		opts = append(opts, WithLatest(r.Latest))
+	}
+	if r.ExcludePrereleases {
+		opts = append(opts, WithoutPrereleases())
+	}
+	if r.PlainHTTP {
+		opts = append(opts, WithPlainHTTP())
+	}
+	if r.Merge {
+		opts = append(opts, WithMerge())
+	}
+	if r.Prune {
+		opts = append(opts, WithPrune(false))
+	}
+	if r.Dependencies {
+		opts = append(opts, WithDependencies())
+	}
+
+	var chartName string
+	switch {
+	case strings.HasPrefix(r.URL, ociScheme+"://"):
+		opts = append(opts, WithOCICharts(r.Charts))
+	case len(r.Charts) == 1:
+		chartName = r.Charts[0]
+	case len(r.Charts) > 1:
+		opts = append(opts, WithChartNames(r.Charts))
+	}
+
+	return NewGetService(config, r.AllVersions, verbose, ignoreErrors, logger, r.NewRootURL, chartName, r.ChartVersion, opts...)
+}
+
+// ManifestService mirrors every repository listed in a manifest
+type ManifestService struct {
+	manifest     *Manifest
+	destination  string
+	verbose      bool
+	ignoreErrors bool
+	logger       *log.Logger
+}
+
+// NewManifestService return a new instance of ManifestService mirroring the
+// repositories of manifest into subfolders of destination
+func NewManifestService(manifest *Manifest, destination string, verbose bool, ignoreErrors bool, logger *log.Logger) *ManifestService {
+	return &ManifestService{
+		manifest:     manifest,
+		destination:  destination,
+		verbose:      verbose,
+		ignoreErrors: ignoreErrors,
+		logger:       logger,
+	}
+}
+
+// Get mirrors the repositories one after the other. A repository failing
+// does not prevent the next ones from being mirrored; the failures are
+// returned together once every repository has been processed.
+func (m *ManifestService) Get() error {
+	var errs []error
+	for _, repository := range m.manifest.Repositories {
+		if m.verbose {
+			m.logger.Printf("Mirroring repository %q from %s", repository.Name, repository.URL)
+		}
+
+		getService := repository.getService(m.destination, m.verbose, m.ignoreErrors, m.logger)
+		if err := os.MkdirAll(getService.config.Name, 0o744); err != nil {
+			errs = append(errs, fmt.Errorf("repository %q: cannot create destination folder: %w", repository.Name, err))
+			continue
+		}
+
+		if err := getService.Get(); err != nil {
+			m.logger.Printf("error: mirroring repository %q: %s", repository.Name, err)
+			errs = append(errs, fmt.Errorf("repository %q: %w", repository.Name, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("cannot mirror repositories: %w", errors.Join(errs...))
+	}
+
+	return nil
+}
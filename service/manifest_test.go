@@ -0,0 +1,127 @@
+package service
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/konstructio/helm-mirror/fixtures"
+)
+
+func TestLoadManifest(t *testing.T) {
+	dir, err := os.MkdirTemp("", "helmmirrortests")
+	if err != nil {
+		t.Errorf("Creating tmp directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	t.Setenv("HELM_MIRROR_TEST_PASSWORD", "secret")
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+		want    *Manifest
+	}{
+		{"1", `
+repositories:
+- name: stable
+  url: https://charts.example.com
+  password: ${HELM_MIRROR_TEST_PASSWORD}
+  headers:
+    X-Api-Key: $HELM_MIRROR_TEST_PASSWORD
+  caFile: certs/ca.pem
+  keyring: /keys/pubring.gpg
+  charts: [nginx, redis]
+  retries: 3
+  retryWait: 2s
+`, false, &Manifest{Repositories: []ManifestRepository{{
+			Name:      "stable",
+			URL:       "https://charts.example.com",
+			Password:  "secret",
+			Headers:   map[string]string{"X-Api-Key": "secret"},
+			CAFile:    path.Join(dir, "certs/ca.pem"),
+			Keyring:   "/keys/pubring.gpg",
+			Charts:    []string{"nginx", "redis"},
+			Retries:   3,
+			RetryWait: 2 * time.Second,
+		}}}},
+		{"2", `repositories: []`, true, nil},
+		{"3", `repositories: [{url: https://charts.example.com}]`, true, nil},
+		{"4", `repositories: [{name: stable, url: ftp://charts.example.com}]`, true, nil},
+		{"5", `repositories: [{name: registry, url: oci://registry.example.com/charts}]`, true, nil},
+		{"6", `repositories: [{name: stable, url: https://charts.example.com, chartVersion: 1.0.0}]`, true, nil},
+		{"7", `repositories: [{name: stable, url: https://charts.example.com, folder: ../stable}]`, true, nil},
+		{"8", `
+repositories:
+- {name: stable, url: https://charts.example.com}
+- {name: other, url: https://other.example.com, folder: ./stable}
+`, true, nil},
+		{"9", `repositories: {name: stable}`, true, nil},
+		{"10", `repositories: [{name: stable, url: https://charts.example.com, merge: true, prune: true}]`, true, nil},
+		{"11", `repositories: [{name: usb, url: usb/charts}, {name: nfs, url: "file:///mnt/nfs/charts"}]`, false, &Manifest{Repositories: []ManifestRepository{
+			{Name: "usb", URL: path.Join(dir, "usb/charts")},
+			{Name: "nfs", URL: "file:///mnt/nfs/charts"},
+		}}},
+		{"12", `repositories: [{name: registry, url: oci://registry.example.com/charts, charts: [nginx], provenance: true}]`, true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manifestPath := path.Join(dir, "mirror.yaml")
+			if err := os.WriteFile(manifestPath, []byte(tt.content), 0o600); err != nil {
+				t.Errorf("writing manifest: %s", err)
+			}
+			got, err := LoadManifest(manifestPath)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("LoadManifest() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LoadManifest() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestManifestService_Get(t *testing.T) {
+	svr := fixtures.NewRepositoryServer()
+	defer svr.Close()
+	dir, err := os.MkdirTemp("", "helmmirrortests")
+	if err != nil {
+		t.Errorf("Creating tmp directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	manifest := &Manifest{Repositories: []ManifestRepository{
+		{Name: "unreachable", URL: "http://127.0.0.1:0"},
+		{Name: "first", URL: svr.URL, Charts: []string{"chart1", "chart2"}},
+		{Name: "second", URL: svr.URL, Folder: "nested/second", Charts: []string{"chart2"}, AllVersions: true},
+	}}
+	m := NewManifestService(manifest, dir, false, true, fakeLogger)
+	if err := m.Get(); err == nil {
+		t.Errorf("ManifestService.Get() error = nil, want the unreachable repository to fail")
+	}
+
+	want := map[string][]string{
+		"first":         {"chart1-2.11.0.tgz", "chart2-1.0.1.tgz", "index.yaml"},
+		"nested/second": {"chart2-0.0.0-rc1.tgz", "chart2-1.0.1.tgz", "index.yaml"},
+	}
+	for folder, wantFiles := range want {
+		files, err := os.ReadDir(path.Join(dir, folder))
+		if err != nil {
+			t.Errorf("reading %s: %s", folder, err)
+			continue
+		}
+		got := []string{}
+		for _, f := range files {
+			if strings.HasSuffix(f.Name(), ".tgz") || f.Name() == indexFileName {
+				got = append(got, f.Name())
+			}
+		}
+		if !reflect.DeepEqual(got, wantFiles) {
+			t.Errorf("ManifestService.Get() %s = %v, want %v", folder, got, wantFiles)
+		}
+	}
+}
@@ -2,6 +2,9 @@ package service
 
 import (
 	"bytes"
+	"fmt"
+	"net/http"
+	"sync"
 
 	"github.com/pkg/errors"
 )
@@ -14,3 +17,41 @@ func (m *mockFormatter) Output(buffer bytes.Buffer) error {
 	}
 	return nil
 }
+
+type mockGetter struct {
+	mu       sync.Mutex
+	content  []byte
+	files    map[string][]byte
+	failURLs map[string]bool
+	missing  map[string]bool
+	calls    []string
+}
+
+func (m *mockGetter) Get(url string) (*bytes.Buffer, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls = append(m.calls, url)
+	if m.failURLs[url] {
+		return nil, errors.New("not found")
+	}
+	if m.missing[url] {
+		return nil, fmt.Errorf("cannot fetch %s: %w", url, &statusError{code: http.StatusNotFound, status: "404 Not Found"})
+	}
+	if content, ok := m.files[url]; ok {
+		return bytes.NewBuffer(content), nil
+	}
+	return bytes.NewBuffer(m.content), nil
+}
+
+type mockPublisher struct {
+	published []string
+}
+
+func (m *mockPublisher) Publish(_ string, name string, version string) (bool, error) {
+	m.published = append(m.published, name+"-"+version)
+	return true, nil
+}
+
+func (m *mockPublisher) String() string {
+	return "mock"
+}
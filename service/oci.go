@@ -0,0 +1,580 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Masterminds/semver"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/repo"
+)
+
+const (
+	ociScheme                 = "oci"
+	helmChartConfigMediaType  = "application/vnd.cncf.helm.config.v1+json"
+	helmChartContentMediaType = "application/vnd.cncf.helm.chart.content.v1.tar+gzip"
+)
+
+// challengeParam matches the key="value" pairs of a WWW-Authenticate header
+var challengeParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// registryClient is a minimal client for the OCI distribution API, enough to
+// pull Helm charts stored as OCI artifacts. It implements getter.Getter for
+// blob URLs so charts can go through the same download pool as classic
+// repositories. Its requests go through getter, and share its retries,
+// timeouts and headers.
+type registryClient struct {
+	getter   *httpGetter
+	scheme   string
+	host     string
+	username string
+	password string
+
+	mu     sync.Mutex
+	tokens map[string]string
+}
+
+func newRegistryClient(host string, getter *httpGetter, config repo.Entry, plainHTTP bool) *registryClient {
+	scheme := "https"
+	if plainHTTP {
+		scheme = "http"
+	}
+
+	return &registryClient{
+		getter:   getter,
+		scheme:   scheme,
+		host:     host,
+		username: config.Username,
+		password: config.Password,
+		tokens:   map[string]string{},
+	}
+}
+
+// parseOCIReference splits an oci://registry/namespace URL into the registry
+// host and the namespace charts live under.
+func parseOCIReference(ref string) (string, string, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid OCI reference %q: %w", ref, err)
+	}
+
+	if u.Scheme != ociScheme || u.Host == "" {
+		return "", "", fmt.Errorf("invalid OCI reference %q: expected oci://registry/namespace", ref)
+	}
+
+	return u.Host, strings.Trim(u.Path, dirSeparator), nil
+}
+
+func (r *registryClient) url(repository, kind, reference string) string {
+	return fmt.Sprintf("%s://%s/v2/%s/%s/%s", r.scheme, r.host, repository, kind, reference)
+}
+
+// Get downloads a blob. It implements getter.Getter.
+func (r *registryClient) Get(blobURL string) (*bytes.Buffer, error) {
+	u, err := url.Parse(blobURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid blob URL %q: %w", blobURL, err)
+	}
+
+	repository, _, found := strings.Cut(strings.TrimPrefix(u.Path, "/v2/"), "/blobs/")
+	if !found {
+		return nil, fmt.Errorf("invalid blob URL %q", blobURL)
+	}
+
+	return r.fetch(repository, blobURL, "")
+}
+
+// tags lists the tags of a repository, following pagination links.
+func (r *registryClient) tags(repository string) ([]string, error) {
+	var tags []string
+	next := r.url(repository, "tags", "list")
+	for next != "" {
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, next, nil)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create request: %w", err)
+		}
+
+		resp, err := r.do(req, repository)
+		if err != nil {
+			return nil, err
+		}
+
+		var list struct {
+			Tags []string `json:"tags"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&list)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode tags of %q: %w", repository, err)
+		}
+		tags = append(tags, list.Tags...)
+
+		next, err = nextLink(req.URL, resp.Header.Values("Link"))
+		if err != nil {
+			return nil, fmt.Errorf("cannot list tags of %q: %w", repository, err)
+		}
+	}
+
+	return tags, nil
+}
+
+// nextLink returns the target of the rel="next" link among the Link header
+// values, resolved against base, or an empty string when there is none.
+func nextLink(base *url.URL, values []string) (string, error) {
+	for _, value := range values {
+		for _, link := range strings.Split(value, ",") {
+			target, params, _ := strings.Cut(strings.TrimSpace(link), ";")
+			target = strings.TrimSpace(target)
+			if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") || !isNextRel(params) {
+				continue
+			}
+
+			ref, err := url.Parse(target[1 : len(target)-1])
+			if err != nil {
+				return "", fmt.Errorf("invalid Link header %q: %w", value, err)
+			}
+
+			return base.ResolveReference(ref).String(), nil
+		}
+	}
+
+	return "", nil
+}
+
+// isNextRel reports whether the parameters of a link include rel="next".
+func isNextRel(params string) bool {
+	for _, param := range strings.Split(params, ";") {
+		key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		if !strings.EqualFold(strings.TrimSpace(key), "rel") {
+			continue
+		}
+		for _, rel := range strings.Fields(strings.Trim(strings.TrimSpace(value), `"`)) {
+			if strings.EqualFold(rel, "next") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// manifest fetches the OCI image manifest of repository:reference.
+func (r *registryClient) manifest(repository, reference string) (*ocispec.Manifest, error) {
+	buf, err := r.fetch(repository, r.url(repository, "manifests", reference), ocispec.MediaTypeImageManifest)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(buf.Bytes(), &manifest); err != nil {
+		return nil, fmt.Errorf("cannot decode manifest of %s:%s: %w", repository, reference, err)
+	}
+
+	return &manifest, nil
+}
+
+func (r *registryClient) fetch(repository, target, accept string) (*bytes.Buffer, error) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, target, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create request: %w", err)
+	}
+
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+
+	resp, err := r.do(req, repository)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	buf := bytes.NewBuffer(nil)
+	if _, err := io.Copy(buf, resp.Body); err != nil {
+		return nil, fmt.Errorf("cannot read %s: %w", target, err)
+	}
+
+	return buf, nil
+}
+
+// do sends req through send, retrying transient failures as the getter does,
+// and turns responses that are not successful into errors.
+func (r *registryClient) do(req *http.Request, repository string) (*http.Response, error) {
+	var resp *http.Response
+	err := r.getter.retry(req.URL.String(), func() (time.Duration, error) {
+		attempt, err := rewind(req)
+		if err != nil {
+			return -1, err
+		}
+
+		resp, err = r.send(attempt, repository)
+		if err != nil {
+			return errorWait(err), err
+		}
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			resp.Body.Close()
+			return statusWait(resp), fmt.Errorf("failed to fetch %s : %w", req.URL, &statusError{code: resp.StatusCode, status: resp.Status})
+		}
+
+		return 0, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// send sends req with the headers of the getter, answering a single
+// authentication challenge from the registry if needed. Tokens are cached per
+// repository.
+func (r *registryClient) send(req *http.Request, repository string) (*http.Response, error) {
+	r.getter.setHeaders(req)
+
+	r.mu.Lock()
+	token := r.tokens[repository]
+	r.mu.Unlock()
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := r.getter.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot reach %s: %w", req.URL, err)
+	}
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		return resp, nil
+	}
+
+	challenge := resp.Header.Get("WWW-Authenticate")
+	resp.Body.Close()
+
+	if err := r.authorize(req, repository, challenge); err != nil {
+		return nil, err
+	}
+
+	retry, err := rewind(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err = r.getter.client.Do(retry)
+	if err != nil {
+		return nil, fmt.Errorf("cannot reach %s: %w", req.URL, err)
+	}
+
+	return resp, nil
+}
+
+// rewind returns a copy of req that can be sent again.
+func rewind(req *http.Request) (*http.Request, error) {
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, fmt.Errorf("cannot rewind request body: %w", err)
+		}
+		retry.Body = body
+	}
+
+	return retry, nil
+}
+
+// authorize sets the Authorization header of req according to challenge,
+// fetching a bearer token from the registry token service when required.
+func (r *registryClient) authorize(req *http.Request, repository, challenge string) error {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	if strings.EqualFold(scheme, "Basic") {
+		if r.username == "" {
+			return fmt.Errorf("registry %s requires credentials", r.host)
+		}
+		req.SetBasicAuth(r.username, r.password)
+		return nil
+	}
+
+	if !strings.EqualFold(scheme, "Bearer") {
+		return fmt.Errorf("unsupported authentication challenge %q from registry %s", challenge, r.host)
+	}
+
+	values := map[string]string{}
+	for _, match := range challengeParam.FindAllStringSubmatch(params, -1) {
+		values[match[1]] = match[2]
+	}
+
+	realm, err := url.Parse(values["realm"])
+	if err != nil || realm.Host == "" {
+		return fmt.Errorf("invalid authentication realm %q from registry %s", values["realm"], r.host)
+	}
+
+	query := realm.Query()
+	if values["service"] != "" {
+		query.Set("service", values["service"])
+	}
+	if values["scope"] != "" {
+		query.Set("scope", values["scope"])
+	}
+	realm.RawQuery = query.Encode()
+
+	tokenReq, err := http.NewRequestWithContext(req.Context(), http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return fmt.Errorf("cannot create token request: %w", err)
+	}
+	if r.username != "" {
+		tokenReq.SetBasicAuth(r.username, r.password)
+	}
+
+	resp, err := r.getter.client.Do(tokenReq)
+	if err != nil {
+		return fmt.Errorf("cannot request token from %s: %w", realm.Host, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("cannot request token from %s: %w", realm.Host, &statusError{code: resp.StatusCode, status: resp.Status})
+	}
+
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return fmt.Errorf("cannot decode token from %s: %w", realm.Host, err)
+	}
+
+	token := body.Token
+	if token == "" {
+		token = body.AccessToken
+	}
+	if token == "" {
+		return fmt.Errorf("empty token returned by %s", realm.Host)
+	}
+
+	r.mu.Lock()
+	r.tokens[repository] = token
+	r.mu.Unlock()
+
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// ociChartVersions returns the tags of repository that are valid chart
+// versions, newest first.
+func ociChartVersions(tags []string) []string {
+	versions := make([]*semver.Version, 0, len(tags))
+	byVersion := map[*semver.Version]string{}
+	for _, tag := range tags {
+		version, err := semver.NewVersion(strings.ReplaceAll(tag, "_", "+"))
+		if err != nil {
+			continue
+		}
+		versions = append(versions, version)
+		byVersion[version] = tag
+	}
+
+	sort.Sort(sort.Reverse(semver.Collection(versions)))
+
+	sorted := make([]string, 0, len(versions))
+	for _, version := range versions {
+		sorted = append(sorted, byVersion[version])
+	}
+
+	return sorted
+}
+
+// getOCI pulls the configured charts from an OCI registry into the
+// destination folder and generates a classic index file for them.
+func (g *GetService) getOCI(constraint *semver.Constraints) error {
+	if g.provenance {
+		return errors.New("provenance files cannot be mirrored from oci:// repositories")
+	}
+
+	host, namespace, err := parseOCIReference(g.config.URL)
+	if err != nil {
+		return err
+	}
+
+	getter, err := g.newHTTPGetter("https://" + host)
+	if err != nil {
+		return fmt.Errorf("cannot construct registry client: %w", err)
+	}
+	// The registry client answers the authentication challenges itself
+	getter.username, getter.password = "", ""
+	client := newRegistryClient(host, getter, g.config, g.plainHTTP)
+
+	if len(g.ociCharts) == 0 {
+		return errors.New("no charts to pull from the OCI registry")
+	}
+
+	var downloads []*chartDownload
+	metadata := map[*chartDownload]*chart.Metadata{}
+	paths := map[string]bool{}
+	for _, ref := range g.ociCharts {
+		name, tag, _ := strings.Cut(ref, ":")
+		repository := strings.TrimPrefix(path.Join(namespace, name), dirSeparator)
+
+		tags := []string{tag}
+		if tag == "" {
+			g.logVerbose("Listing tags of %s/%s", host, repository)
+			all, err := client.tags(repository)
+			if err != nil {
+				if g.ignoreErrors {
+					g.logger.Printf("WARNING: listing tags of chart %s - %s", name, err)
+					continue
+				}
+				return fmt.Errorf("cannot list tags of chart %s: %w", name, err)
+			}
+
+			tags = nil
+			for _, tag := range ociChartVersions(all) {
+				version := strings.ReplaceAll(tag, "_", "+")
+				if constraint != nil && !matchesConstraint(constraint, version) {
+					continue
+				}
+				if g.excludePrereleases && isPrerelease(version) {
+					continue
+				}
+				tags = append(tags, tag)
+			}
+
+			keep := g.versionsPerChart(constraint)
+			if keep == 0 && !g.allVersions && constraint == nil {
+				keep = 1
+			}
+			if keep > 0 && len(tags) > keep {
+				tags = tags[:keep]
+			}
+
+			if ranges, ok := g.versionRanges[name]; ok {
+				tags = newestTagsInRanges(tags, ranges)
+			}
+		}
+
+		for _, tag := range tags {
+			download, md, err := g.resolveOCIChart(client, repository, tag)
+			if err != nil {
+				if g.ignoreErrors {
+					g.logger.Printf("WARNING: processing chart %s(%s) - %s", name, tag, err)
+					continue
+				}
+				return fmt.Errorf("cannot resolve chart %s(%s): %w", name, tag, err)
+			}
+
+			if paths[download.path] {
+				g.logVerbose("Skipping chart %q (version %s): listed more than once", download.name, download.version)
+				continue
+			}
+			paths[download.path] = true
+
+			downloads = append(downloads, download)
+			metadata[download] = md
+		}
+	}
+
+	if g.dryRun {
+		return g.printPlan(downloads, nil)
+	}
+
+	g.downloadCharts(client, nil, downloads)
+	if err := g.checkDownloads(downloads); err != nil {
+		return err
+	}
+
+	if err := g.publishCharts(downloads); err != nil {
+		return err
+	}
+
+	if g.dependencyState != nil {
+		g.dependencyState.downloads = append(g.dependencyState.downloads, downloads...)
+	}
+
+	index := repo.NewIndexFile()
+	for _, download := range downloads {
+		if !download.mirrored() {
+			continue
+		}
+		index.Add(metadata[download], path.Base(download.path), g.newRootURL, strings.TrimPrefix(download.digest, "sha256:"))
+	}
+	index.SortEntries()
+	if err := g.mergeIndex(index); err != nil {
+		return err
+	}
+
+	indexPath := path.Join(g.config.Name, indexFileName)
+	g.logVerbose("Writing index file %q with %d charts", indexPath, len(index.Entries))
+	if err := index.WriteFile(indexPath, 0o644); err != nil {
+		return fmt.Errorf("cannot write index file: %w", err)
+	}
+
+	if err := g.mirrorDependencies(downloads); err != nil {
+		return err
+	}
+
+	if err := g.pruneCharts(downloads); err != nil {
+		return err
+	}
+
+	g.logVerbose("Operation completed successfully")
+	return nil
+}
+
+// resolveOCIChart reads the manifest and chart metadata of repository:tag
+// and describes the chart layer to download.
+func (g *GetService) resolveOCIChart(client *registryClient, repository, tag string) (*chartDownload, *chart.Metadata, error) {
+	g.logVerbose("Fetching manifest of %s:%s", repository, tag)
+	manifest, err := client.manifest(repository, tag)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if manifest.Config.MediaType != helmChartConfigMediaType {
+		return nil, nil, fmt.Errorf("%s:%s is not a Helm chart (config media type %q)", repository, tag, manifest.Config.MediaType)
+	}
+
+	var layer *ocispec.Descriptor
+	for i := range manifest.Layers {
+		if manifest.Layers[i].MediaType == helmChartContentMediaType {
+			layer = &manifest.Layers[i]
+			break
+		}
+	}
+	if layer == nil {
+		return nil, nil, fmt.Errorf("%s:%s has no chart content layer", repository, tag)
+	}
+
+	config, err := client.Get(client.url(repository, "blobs", manifest.Config.Digest.String()))
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot download chart metadata: %w", err)
+	}
+
+	var md chart.Metadata
+	if err := json.Unmarshal(config.Bytes(), &md); err != nil {
+		return nil, nil, fmt.Errorf("cannot decode chart metadata: %w", err)
+	}
+
+	download := &chartDownload{
+		name:    md.Name,
+		version: md.Version,
+		digest:  layer.Digest.String(),
+		urls:    []string{client.url(repository, "blobs", layer.Digest.String())},
+		path:    path.Join(g.config.Name, fmt.Sprintf("%s-%s.tgz", md.Name, md.Version)),
+		size:    layer.Size,
+	}
+	download.upToDate = isUpToDate(download.path, download.digest)
+
+	return download, &md, nil
+}
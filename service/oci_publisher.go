@@ -0,0 +1,206 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/repo"
+)
+
+// OCIPublisher pushes charts as Helm OCI artifacts to a registry
+type OCIPublisher struct {
+	ref       string
+	namespace string
+	client    *registryClient
+}
+
+// NewOCIPublisher returns a publisher pushing charts under the
+// oci://registry/namespace reference in config.URL, using the credentials
+// and TLS files in config.
+func NewOCIPublisher(config repo.Entry, plainHTTP bool) (*OCIPublisher, error) {
+	host, namespace, err := parseOCIReference(config.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	transport, err := newTransport(config, "https://"+host)
+	if err != nil {
+		return nil, fmt.Errorf("cannot construct registry client: %w", err)
+	}
+	getter := &httpGetter{
+		client:    &http.Client{Transport: transport},
+		retryWait: defaultRetryWait,
+		logf:      func(string, ...any) {},
+	}
+
+	return &OCIPublisher{
+		ref:       config.URL,
+		namespace: namespace,
+		client:    newRegistryClient(host, getter, config, plainHTTP),
+	}, nil
+}
+
+func (o *OCIPublisher) String() string {
+	return o.ref
+}
+
+// Publish pushes the chart at chartPath as name:version, unless the tag
+// already exists in the registry.
+func (o *OCIPublisher) Publish(chartPath string, name string, version string) (bool, error) {
+	repository := strings.TrimPrefix(path.Join(o.namespace, name), dirSeparator)
+	// OCI tags cannot contain '+', Helm replaces it with '_'
+	tag := strings.ReplaceAll(version, "+", "_")
+
+	exists, err := o.client.exists(repository, "manifests", tag)
+	if err != nil {
+		return false, err
+	}
+	if exists {
+		return false, nil
+	}
+
+	loaded, err := chartutil.Load(chartPath)
+	if err != nil {
+		return false, fmt.Errorf("cannot load chart %q: %w", chartPath, err)
+	}
+
+	config, err := json.Marshal(loaded.GetMetadata())
+	if err != nil {
+		return false, fmt.Errorf("cannot encode chart metadata: %w", err)
+	}
+
+	content, err := os.ReadFile(chartPath)
+	if err != nil {
+		return false, fmt.Errorf("cannot read chart %q: %w", chartPath, err)
+	}
+
+	configDesc, err := o.client.pushBlob(repository, helmChartConfigMediaType, config)
+	if err != nil {
+		return false, err
+	}
+
+	contentDesc, err := o.client.pushBlob(repository, helmChartContentMediaType, content)
+	if err != nil {
+		return false, err
+	}
+
+	manifest := ocispec.Manifest{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    configDesc,
+		Layers:    []ocispec.Descriptor{contentDesc},
+	}
+	manifest.SchemaVersion = 2
+
+	if err := o.client.pushManifest(repository, tag, manifest); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// exists reports whether repository holds the given manifest or blob.
+func (r *registryClient) exists(repository, kind, reference string) (bool, error) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodHead, r.url(repository, kind, reference), nil)
+	if err != nil {
+		return false, fmt.Errorf("cannot create request: %w", err)
+	}
+
+	if kind == "manifests" {
+		req.Header.Set("Accept", ocispec.MediaTypeImageManifest)
+	}
+
+	resp, err := r.do(req, repository)
+	if isNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("cannot check %s:%s: %w", repository, reference, err)
+	}
+	resp.Body.Close()
+
+	return true, nil
+}
+
+// pushBlob uploads content to repository in a single request, unless the
+// registry already holds it.
+func (r *registryClient) pushBlob(repository, mediaType string, content []byte) (ocispec.Descriptor, error) {
+	desc := ocispec.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromBytes(content),
+		Size:      int64(len(content)),
+	}
+
+	exists, err := r.exists(repository, "blobs", desc.Digest.String())
+	if err != nil {
+		return desc, err
+	}
+	if exists {
+		return desc, nil
+	}
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, r.url(repository, "blobs", "uploads/"), nil)
+	if err != nil {
+		return desc, fmt.Errorf("cannot create request: %w", err)
+	}
+
+	resp, err := r.do(req, repository)
+	if err != nil {
+		return desc, fmt.Errorf("cannot start blob upload: %w", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	location, err := req.URL.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		return desc, fmt.Errorf("invalid blob upload location %q: %w", resp.Header.Get("Location"), err)
+	}
+	query := location.Query()
+	query.Set("digest", desc.Digest.String())
+	location.RawQuery = query.Encode()
+
+	req, err = http.NewRequestWithContext(context.Background(), http.MethodPut, location.String(), bytes.NewReader(content))
+	if err != nil {
+		return desc, fmt.Errorf("cannot create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	resp, err = r.do(req, repository)
+	if err != nil {
+		return desc, fmt.Errorf("cannot upload blob %s: %w", desc.Digest, err)
+	}
+	resp.Body.Close()
+
+	return desc, nil
+}
+
+// pushManifest uploads manifest to repository under tag.
+func (r *registryClient) pushManifest(repository, tag string, manifest ocispec.Manifest) error {
+	content, err := json.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("cannot encode manifest: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPut, r.url(repository, "manifests", tag), bytes.NewReader(content))
+	if err != nil {
+		return fmt.Errorf("cannot create request: %w", err)
+	}
+	req.Header.Set("Content-Type", ocispec.MediaTypeImageManifest)
+
+	resp, err := r.do(req, repository)
+	if err != nil {
+		return fmt.Errorf("cannot upload manifest %s:%s: %w", repository, tag, err)
+	}
+	resp.Body.Close()
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package service
+
+import (
+	"net/http/httptest"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/konstructio/helm-mirror/fixtures"
+	"k8s.io/helm/pkg/repo"
+)
+
+func TestOCIPublisher_Publish(t *testing.T) {
+	dir, err := prepareTmp()
+	if err != nil {
+		t.Errorf("loading testdata: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	registry := fixtures.NewRegistry("user", "secret")
+	registry.AddChart("mirror/existing", "0.1.0", []byte("existing"), []byte(`{"name":"existing","version":"0.1.0"}`))
+	svr := httptest.NewServer(registry)
+	defer svr.Close()
+	host := strings.TrimPrefix(svr.URL, "http://")
+	chartPath := path.Join(dir, "processtgz", "chart1.tgz")
+	tests := []struct {
+		name          string
+		password      string
+		chartPath     string
+		chartName     string
+		version       string
+		want          bool
+		wantErr       bool
+		wantTagsIn    string
+		wantTagsAfter []string
+	}{
+		{"1", "secret", chartPath, "signtest", "0.1.0", true, false, "mirror/signtest", []string{"0.1.0"}},
+		{"2", "secret", chartPath, "signtest", "0.1.0", false, false, "mirror/signtest", []string{"0.1.0"}},
+		{"3", "secret", chartPath, "existing", "0.1.0", false, false, "mirror/existing", []string{"0.1.0"}},
+		{"4", "secret", chartPath, "signtest", "0.2.0+build.1", true, false, "mirror/signtest", []string{"0.1.0", "0.2.0_build.1"}},
+		{"5", "wrong", chartPath, "other", "0.1.0", false, true, "mirror/other", []string{}},
+		{"6", "secret", path.Join(dir, "missing.tgz"), "other", "0.1.0", false, true, "mirror/other", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			publisher, err := NewOCIPublisher(repo.Entry{URL: "oci://" + host + "/mirror", Username: "user", Password: tt.password}, true)
+			if err != nil {
+				t.Errorf("NewOCIPublisher() error = %v", err)
+				return
+			}
+			got, err := publisher.Publish(tt.chartPath, tt.chartName, tt.version)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("OCIPublisher.Publish() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("OCIPublisher.Publish() = %v, want %v", got, tt.want)
+			}
+			if tags := registry.Tags(tt.wantTagsIn); !reflect.DeepEqual(tags, tt.wantTagsAfter) {
+				t.Errorf("OCIPublisher.Publish() tags = %v, want %v", tags, tt.wantTagsAfter)
+			}
+		})
+	}
+}
+
+func TestGetService_publishCharts(t *testing.T) {
+	registry := fixtures.NewRegistry("", "")
+	registry.AddChart("charts/nginx", "1.0.0", []byte("nginx-1.0.0"), []byte(`{"name":"nginx","version":"1.0.0"}`))
+	svr := httptest.NewServer(registry)
+	defer svr.Close()
+	host := strings.TrimPrefix(svr.URL, "http://")
+	publisher, err := NewOCIPublisher(repo.Entry{URL: "oci://" + host + "/mirror"}, true)
+	if err != nil {
+		t.Errorf("NewOCIPublisher() error = %v", err)
+	}
+	tests := []struct {
+		name         string
+		ignoreErrors bool
+		wantErr      bool
+	}{
+		{"1", false, true},
+		{"2", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := os.MkdirTemp("", "helmmirrortests")
+			if err != nil {
+				t.Errorf("Creating tmp directory: %s", err)
+			}
+			defer os.RemoveAll(dir)
+			g := &GetService{
+				config:       repo.Entry{Name: dir, URL: "oci://" + host + "/charts"},
+				logger:       fakeLogger,
+				ignoreErrors: tt.ignoreErrors,
+				ociCharts:    []string{"nginx"},
+				plainHTTP:    true,
+				publishers:   []Publisher{publisher},
+			}
+			// the pulled chart is not a valid archive, so it cannot be published
+			if err := g.Get(); (err != nil) != tt.wantErr {
+				t.Errorf("GetService.Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
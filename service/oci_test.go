@@ -0,0 +1,253 @@
+package service
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/konstructio/helm-mirror/fixtures"
+	"k8s.io/helm/pkg/repo"
+)
+
+func Test_parseOCIReference(t *testing.T) {
+	tests := []struct {
+		name          string
+		ref           string
+		wantHost      string
+		wantNamespace string
+		wantErr       bool
+	}{
+		{"1", "oci://registry.example.com/charts", "registry.example.com", "charts", false},
+		{"2", "oci://registry.example.com:5000/org/charts/", "registry.example.com:5000", "org/charts", false},
+		{"3", "oci://registry.example.com", "registry.example.com", "", false},
+		{"4", "https://registry.example.com/charts", "", "", true},
+		{"5", "oci:///charts", "", "", true},
+		{"6", "%", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host, namespace, err := parseOCIReference(tt.ref)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseOCIReference() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if host != tt.wantHost || namespace != tt.wantNamespace {
+				t.Errorf("parseOCIReference() = %q, %q, want %q, %q", host, namespace, tt.wantHost, tt.wantNamespace)
+			}
+		})
+	}
+}
+
+func Test_ociChartVersions(t *testing.T) {
+	tests := []struct {
+		name string
+		tags []string
+		want []string
+	}{
+		{"1", []string{"1.0.0", "2.0.0", "1.10.0"}, []string{"2.0.0", "1.10.0", "1.0.0"}},
+		{"2", []string{"latest", "1.0.0", "sha256-abc"}, []string{"1.0.0"}},
+		{"3", []string{"1.0.0_build.1", "0.9.0"}, []string{"1.0.0_build.1", "0.9.0"}},
+		{"4", []string{}, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ociChartVersions(tt.tags); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ociChartVersions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_nextLink(t *testing.T) {
+	base, _ := url.Parse("https://registry.example.com/v2/charts/nginx/tags/list")
+	tests := []struct {
+		name    string
+		values  []string
+		want    string
+		wantErr bool
+	}{
+		{"1", nil, "", false},
+		{"2", []string{`</v2/charts/nginx/tags/list?last=1.0.0&n=1>; rel="next"`}, "https://registry.example.com/v2/charts/nginx/tags/list?last=1.0.0&n=1", false},
+		{"3", []string{`<https://mirror.example.com/v2/charts/nginx/tags/list?last=1.0.0>; rel=next`}, "https://mirror.example.com/v2/charts/nginx/tags/list?last=1.0.0", false},
+		{"4", []string{`</v2/charts/nginx/tags/list>; rel="first", <list?last=1.0.0>; type="json"; rel="prefetch next"`}, "https://registry.example.com/v2/charts/nginx/tags/list?last=1.0.0", false},
+		{"5", []string{`</v2/charts/nginx/tags/list>; rel="prev"`, `</v2/charts/nginx/tags/list?last=2.0.0>; REL="Next"`}, "https://registry.example.com/v2/charts/nginx/tags/list?last=2.0.0", false},
+		{"6", []string{`</v2/charts/nginx/tags/list>; rel="prev"`}, "", false},
+		{"7", []string{`<%zz>; rel="next"`}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := nextLink(base, tt.values)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("nextLink() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("nextLink() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetService_getOCI(t *testing.T) {
+	registry := fixtures.NewRegistry("user", "secret")
+	registry.AddChart("charts/nginx", "1.0.0", []byte("nginx-1.0.0"), []byte(`{"name":"nginx","version":"1.0.0","apiVersion":"v2"}`))
+	registry.AddChart("charts/nginx", "1.1.0", []byte("nginx-1.1.0"), []byte(`{"name":"nginx","version":"1.1.0","apiVersion":"v2"}`))
+	registry.AddChart("charts/redis", "2.0.0", []byte("redis-2.0.0"), []byte(`{"name":"redis","version":"2.0.0"}`))
+	registry.AddChart("charts/image", "1.0.0", []byte("image"), []byte(`{}`))
+	svr := httptest.NewServer(registry)
+	defer svr.Close()
+	host := strings.TrimPrefix(svr.URL, "http://")
+	type fields struct {
+		url          string
+		username     string
+		password     string
+		charts       []string
+		allVersions  bool
+		ignoreErrors bool
+	}
+	tests := []struct {
+		name        string
+		fields      fields
+		wantErr     bool
+		wantCharts  []string
+		wantEntries int
+	}{
+		{"1", fields{"oci://" + host + "/charts", "user", "secret", []string{"nginx"}, false, false}, false, []string{"nginx-1.1.0.tgz"}, 1},
+		{"2", fields{"oci://" + host + "/charts", "user", "secret", []string{"nginx"}, true, false}, false, []string{"nginx-1.0.0.tgz", "nginx-1.1.0.tgz"}, 2},
+		{"3", fields{"oci://" + host + "/charts", "user", "secret", []string{"nginx:1.0.0", "redis"}, false, false}, false, []string{"nginx-1.0.0.tgz", "redis-2.0.0.tgz"}, 2},
+		{"4", fields{"oci://" + host + "/charts", "user", "wrong", []string{"nginx"}, false, false}, true, nil, 0},
+		{"5", fields{"oci://" + host + "/charts", "user", "secret", []string{"missing"}, false, false}, true, nil, 0},
+		{"6", fields{"oci://" + host + "/charts", "user", "secret", []string{"missing", "redis"}, false, true}, false, []string{"redis-2.0.0.tgz"}, 1},
+		{"7", fields{"oci://" + host + "/charts", "user", "secret", []string{"nginx:9.9.9"}, false, false}, true, nil, 0},
+		{"8", fields{"oci://" + host + "/charts", "user", "secret", nil, false, false}, true, nil, 0},
+		{"9", fields{"oci://" + host, "user", "secret", []string{"charts/redis"}, false, false}, false, []string{"redis-2.0.0.tgz"}, 1},
+		{"10", fields{"oci://" + host + "/charts", "user", "secret", []string{"nginx", "nginx:1.1.0"}, false, false}, false, []string{"nginx-1.1.0.tgz"}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			g := &GetService{
+				config:       repo.Entry{Name: dir, URL: tt.fields.url, Username: tt.fields.username, Password: tt.fields.password},
+				logger:       fakeLogger,
+				allVersions:  tt.fields.allVersions,
+				ignoreErrors: tt.fields.ignoreErrors,
+				ociCharts:    tt.fields.charts,
+				plainHTTP:    true,
+			}
+			if err := g.Get(); (err != nil) != tt.wantErr {
+				t.Errorf("GetService.Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			for _, chart := range tt.wantCharts {
+				if !fileExists(path.Join(dir, chart)) {
+					t.Errorf("GetService.Get() chart %s not downloaded", chart)
+				}
+			}
+			index, err := repo.LoadIndexFile(path.Join(dir, "index.yaml"))
+			if err != nil {
+				t.Errorf("GetService.Get() cannot load index: %s", err)
+				return
+			}
+			entries := 0
+			for _, versions := range index.Entries {
+				entries += len(versions)
+			}
+			if entries != tt.wantEntries {
+				t.Errorf("GetService.Get() index entries = %v, want %v", entries, tt.wantEntries)
+			}
+		})
+	}
+}
+
+func TestGetService_getOCIThroughGetter(t *testing.T) {
+	registry := fixtures.NewRegistry("user", "secret")
+	registry.PageSize = 1
+	for _, version := range []string{"1.0.0", "1.1.0", "1.2.0"} {
+		registry.AddChart("charts/nginx", version, []byte("nginx-"+version), []byte(`{"name":"nginx","version":"`+version+`","apiVersion":"v2"}`))
+	}
+	var mu sync.Mutex
+	failed := map[string]bool{}
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/v2/") && r.Header.Get("X-Api-Key") != "key" {
+			t.Errorf("GetService.Get() request to %s without the configured headers", r.URL)
+		}
+		mu.Lock()
+		fail := !failed[r.URL.String()]
+		failed[r.URL.String()] = true
+		mu.Unlock()
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		registry.ServeHTTP(w, r)
+	}))
+	defer svr.Close()
+
+	dir := t.TempDir()
+	g := NewGetService(repo.Entry{Name: dir, URL: "oci://" + strings.TrimPrefix(svr.URL, "http://") + "/charts", Username: "user", Password: "secret"}, true, false, false, fakeLogger, "", "", "",
+		WithOCICharts([]string{"nginx"}), WithPlainHTTP(), WithRetries(2, time.Millisecond), WithHeaders(http.Header{"X-Api-Key": {"key"}}))
+	if err := g.Get(); err != nil {
+		t.Fatalf("GetService.Get() error = %v", err)
+	}
+
+	for _, version := range []string{"1.0.0", "1.1.0", "1.2.0"} {
+		if !fileExists(path.Join(dir, "nginx-"+version+".tgz")) {
+			t.Errorf("GetService.Get() chart nginx-%s not downloaded", version)
+		}
+	}
+}
+
+func TestGetService_getOCIProvenance(t *testing.T) {
+	dir, err := os.MkdirTemp("", "helmmirrortests")
+	if err != nil {
+		t.Errorf("Creating tmp directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	g := NewGetService(repo.Entry{Name: dir, URL: "oci://registry.invalid/charts"}, false, false, false, fakeLogger, "", "", "", WithOCICharts([]string{"nginx"}), WithProvenance(""))
+	if err := g.Get(); err == nil || !strings.Contains(err.Error(), "provenance") {
+		t.Errorf("GetService.Get() error = %v, want provenance to be rejected for oci:// repositories", err)
+	}
+}
+
+func TestGetService_getOCIPruneDependencies(t *testing.T) {
+	registry := fixtures.NewRegistry("", "")
+	svr := httptest.NewServer(registry)
+	defer svr.Close()
+	repoURL := "oci://" + strings.TrimPrefix(svr.URL, "http://") + "/charts"
+	registry.AddChart("charts/app", "1.0.0", chartArchive(t, map[string]string{
+		"app/Chart.yaml": "apiVersion: v2\nname: app\nversion: 1.0.0\ndependencies:\n- name: db\n  version: ~1.0.0\n  repository: " + repoURL + "\n",
+	}), []byte(`{"name":"app","version":"1.0.0","apiVersion":"v2"}`))
+	registry.AddChart("charts/db", "1.0.0", chartArchive(t, map[string]string{"db/Chart.yaml": "apiVersion: v2\nname: db\nversion: 1.0.0\n"}), []byte(`{"name":"db","version":"1.0.0","apiVersion":"v2"}`))
+
+	dir := t.TempDir()
+	os.WriteFile(path.Join(dir, "old-0.1.0.tgz"), []byte("old"), 0o600)
+	g := NewGetService(repo.Entry{Name: dir, URL: repoURL}, false, false, false, fakeLogger, "", "", "", WithOCICharts([]string{"app"}), WithPlainHTTP(), WithDependencies(), WithPrune(false))
+	if err := g.Get(); err != nil {
+		t.Errorf("GetService.Get() error = %v", err)
+	}
+
+	for name, want := range map[string]bool{"app-1.0.0.tgz": true, "db-1.0.0.tgz": true, "old-0.1.0.tgz": false} {
+		if got := fileExists(path.Join(dir, name)); got != want {
+			t.Errorf("GetService.Get() %s present = %v, want %v", name, got, want)
+		}
+	}
+
+	var out bytes.Buffer
+	g = NewGetService(repo.Entry{Name: dir, URL: repoURL}, false, false, false, log.New(&out, "", 0), "", "", "", WithOCICharts([]string{"app"}), WithPlainHTTP(), WithDependencies(), WithPrune(true))
+	if err := g.Get(); err != nil {
+		t.Errorf("GetService.Get() error = %v", err)
+	}
+	if strings.Contains(out.String(), "Would prune") {
+		t.Errorf("GetService.Get() would prune the dependencies: %s", out.String())
+	}
+}
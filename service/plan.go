@@ -0,0 +1,251 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"sync"
+	"text/tabwriter"
+)
+
+// PlanFormat selects how the plan of a dry run is printed
+type PlanFormat string
+
+const (
+	// PlanText prints the plan as a table followed by a summary
+	PlanText PlanFormat = "text"
+	// PlanJSON prints the plan as a JSON document
+	PlanJSON PlanFormat = "json"
+)
+
+// PlanAction is what a mirror run would do with a chart version
+type PlanAction string
+
+const (
+	// PlanDownload charts would be downloaded
+	PlanDownload PlanAction = "download"
+	// PlanUpToDate charts would be skipped, the local copy being up to date
+	PlanUpToDate PlanAction = "up-to-date"
+	// PlanPrune files would be deleted from the destination folder
+	PlanPrune PlanAction = "prune"
+)
+
+// Plan describes what a mirror run would do
+type Plan struct {
+	Charts []PlannedChart `json:"charts"`
+	Prune  []PlannedChart `json:"prune"`
+	// DownloadSize is the total size of the charts to download whose size
+	// is known, in bytes
+	DownloadSize int64 `json:"downloadSize"`
+	// UnknownSizes counts the charts to download whose size is unknown
+	UnknownSizes int `json:"unknownSizes"`
+}
+
+// PlannedChart is a chart version, or for pruning a file, a mirror run would
+// process
+type PlannedChart struct {
+	Name    string     `json:"name,omitempty"`
+	Version string     `json:"version,omitempty"`
+	Action  PlanAction `json:"action"`
+	Path    string     `json:"path"`
+	Size    int64      `json:"size,omitempty"`
+}
+
+// WithDryRun does not download, publish, prune or write anything in the
+// destination folder, which does not have to exist: the charts that would be
+// downloaded, skipped as up to date or pruned are printed to out in the given
+// format instead. The index file of the repository is fetched into a
+// temporary folder.
+func WithDryRun(out io.Writer, format PlanFormat) GetOption {
+	return func(g *GetService) {
+		g.dryRun = true
+		g.planOut = out
+		g.planFormat = format
+	}
+}
+
+// printPlan prints the plan of downloads. The size of the charts to download
+// is taken from sizeOf when it is not known yet.
+func (g *GetService) printPlan(downloads []*chartDownload, sizeOf func(download *chartDownload) int64) error {
+	if sizeOf != nil {
+		g.fetchSizes(downloads, sizeOf)
+	}
+
+	plan := &Plan{Charts: []PlannedChart{}, Prune: []PlannedChart{}}
+	for _, download := range downloads {
+		if download.err != nil {
+			continue
+		}
+
+		planned := PlannedChart{
+			Name:    download.name,
+			Version: download.version,
+			Action:  PlanDownload,
+			Path:    download.path,
+			Size:    download.size,
+		}
+
+		if download.upToDate {
+			planned.Action = PlanUpToDate
+			planned.Size = localSize(download.path)
+		} else if planned.Size > 0 {
+			plan.DownloadSize += planned.Size
+		} else {
+			plan.UnknownSizes++
+		}
+
+		plan.Charts = append(plan.Charts, planned)
+	}
+
+	if g.prune {
+		stale, err := staleChartFiles(g.config.Name, downloads)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		for _, name := range stale {
+			plan.Prune = append(plan.Prune, PlannedChart{Action: PlanPrune, Path: name, Size: localSize(name)})
+		}
+	}
+
+	if g.planFormat == PlanJSON {
+		encoder := json.NewEncoder(g.planOut)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(plan); err != nil {
+			return fmt.Errorf("cannot write plan: %w", err)
+		}
+		return nil
+	}
+
+	return writePlanText(g.planOut, plan)
+}
+
+// fetchSizes sets the size of the charts to download that is not known yet
+// from sizeOf, with as many requests in flight as downloads would have.
+func (g *GetService) fetchSizes(downloads []*chartDownload, sizeOf func(download *chartDownload) int64) {
+	workers := min(max(g.concurrency, 1), len(downloads))
+
+	var wg sync.WaitGroup
+	queue := make(chan *chartDownload)
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for download := range queue {
+				download.size = sizeOf(download)
+			}
+		}()
+	}
+
+	for _, download := range downloads {
+		if !download.upToDate && download.size == 0 {
+			queue <- download
+		}
+	}
+	close(queue)
+	wg.Wait()
+}
+
+func writePlanText(out io.Writer, plan *Plan) error {
+	writer := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "ACTION\tCHART\tVERSION\tSIZE")
+	for _, planned := range slices.Concat(plan.Charts, plan.Prune) {
+		name := planned.Name
+		if planned.Action == PlanPrune {
+			name = path.Base(planned.Path)
+		}
+
+		size := "-"
+		if planned.Size > 0 {
+			size = formatSize(planned.Size)
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", planned.Action, name, planned.Version, size)
+	}
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("cannot write plan: %w", err)
+	}
+
+	toDownload := len(plan.Charts)
+	for _, planned := range plan.Charts {
+		if planned.Action == PlanUpToDate {
+			toDownload--
+		}
+	}
+
+	summary := fmt.Sprintf("%d to download (%s", toDownload, formatSize(plan.DownloadSize))
+	if plan.UnknownSizes > 0 {
+		summary += fmt.Sprintf(", size unknown for %d", plan.UnknownSizes)
+	}
+	summary += fmt.Sprintf("), %d up to date, %d to prune", len(plan.Charts)-toDownload, len(plan.Prune))
+
+	if _, err := fmt.Fprintln(out, summary); err != nil {
+		return fmt.Errorf("cannot write plan: %w", err)
+	}
+
+	return nil
+}
+
+// formatSize prints size in bytes with a binary unit.
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+func localSize(name string) int64 {
+	info, err := os.Stat(name)
+	if err != nil {
+		return 0
+	}
+
+	return info.Size()
+}
+
+// remoteSize returns the size the server advertises for the first URL of
+// download, or zero when it does not. Charts read from disk are measured
+// there.
+func (g *GetService) remoteSize(client *httpGetter, download *chartDownload) int64 {
+	if len(download.urls) == 0 {
+		return 0
+	}
+
+	if name, err := localPath(download.urls[0]); err == nil {
+		info, err := os.Stat(name)
+		if err != nil {
+			g.logVerbose("Cannot get size of chart %q (version %s): %s", download.name, download.version, err)
+			return 0
+		}
+		return info.Size()
+	}
+
+	req, err := client.newRequest(http.MethodHead, download.urls[0])
+	if err != nil {
+		return 0
+	}
+
+	resp, err := client.client.Do(req)
+	if err != nil {
+		g.logVerbose("Cannot get size of chart %q (version %s): %s", download.name, download.version, err)
+		return 0
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 || resp.ContentLength < 0 {
+		return 0
+	}
+
+	return resp.ContentLength
+}
@@ -0,0 +1,148 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/konstructio/helm-mirror/fixtures"
+	"k8s.io/helm/pkg/repo"
+)
+
+func TestGetService_GetDryRun(t *testing.T) {
+	svr := fixtures.NewRepositoryServer()
+	defer svr.Close()
+	dir, err := os.MkdirTemp("", "helmmirrortests")
+	if err != nil {
+		t.Errorf("Creating tmp directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// chart1 is up to date and old-0.1.0.tgz is no longer mirrored
+	os.WriteFile(path.Join(dir, "chart1-2.11.0.tgz"), fixtures.ChartTGZ(), 0o600)
+	os.WriteFile(path.Join(dir, "old-0.1.0.tgz"), []byte("old"), 0o600)
+	os.WriteFile(path.Join(dir, downloadedFileName), []byte("previous"), 0o600)
+
+	var out bytes.Buffer
+	g := NewGetService(repo.Entry{Name: dir, URL: svr.URL}, false, false, true, fakeLogger, "", "", "", WithIncludes([]string{"chart[12]"}), WithPrune(false), WithDryRun(&out, PlanJSON))
+	if err := g.Get(); err != nil {
+		t.Errorf("GetService.Get() error = %v", err)
+		return
+	}
+
+	var plan Plan
+	if err := json.Unmarshal(out.Bytes(), &plan); err != nil {
+		t.Errorf("decoding plan %q: %s", out.String(), err)
+	}
+	size := int64(len(fixtures.ChartTGZ()))
+	want := Plan{
+		Charts: []PlannedChart{
+			{Name: "chart1", Version: "2.11.0", Action: PlanUpToDate, Path: path.Join(dir, "chart1-2.11.0.tgz"), Size: size},
+			{Name: "chart2", Version: "1.0.1", Action: PlanDownload, Path: path.Join(dir, "chart2-1.0.1.tgz"), Size: size},
+		},
+		Prune:        []PlannedChart{{Action: PlanPrune, Path: path.Join(dir, "old-0.1.0.tgz"), Size: 3}},
+		DownloadSize: size,
+	}
+	if !reflect.DeepEqual(plan, want) {
+		t.Errorf("GetService.Get() plan = %+v, want %+v", plan, want)
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Errorf("reading destination: %s", err)
+	}
+	if len(files) != 3 {
+		t.Errorf("GetService.Get() wrote to the destination folder during a dry run: %v", files)
+	}
+	if content, _ := os.ReadFile(path.Join(dir, downloadedFileName)); string(content) != "previous" {
+		t.Errorf("GetService.Get() replaced the index file of the last run during a dry run: %.20q", content)
+	}
+
+	out.Reset()
+	g = NewGetService(repo.Entry{Name: dir, URL: svr.URL}, false, false, true, fakeLogger, "", "", "", WithIncludes([]string{"chart[12]"}), WithPrune(false), WithDryRun(&out, PlanText))
+	if err := g.Get(); err != nil {
+		t.Errorf("GetService.Get() error = %v", err)
+		return
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	wantLines := []string{
+		"ACTION      CHART          VERSION  SIZE",
+		"up-to-date  chart1         2.11.0   45 B",
+		"download    chart2         1.0.1    45 B",
+		"prune       old-0.1.0.tgz           3 B",
+		"1 to download (45 B), 1 up to date, 1 to prune",
+	}
+	if !reflect.DeepEqual(lines, wantLines) {
+		t.Errorf("GetService.Get() plan =\n%s\nwant\n%s", strings.Join(lines, "\n"), strings.Join(wantLines, "\n"))
+	}
+}
+
+func TestGetService_GetDryRunMissingFolder(t *testing.T) {
+	svr := fixtures.NewRepositoryServer()
+	defer svr.Close()
+	dir := path.Join(t.TempDir(), "mirror")
+
+	var out bytes.Buffer
+	g := NewGetService(repo.Entry{Name: dir, URL: svr.URL}, false, false, true, fakeLogger, "", "", "", WithPrune(false), WithDryRun(&out, PlanText))
+	if err := g.Get(); err != nil {
+		t.Errorf("GetService.Get() error = %v", err)
+	}
+	if fileExists(dir) {
+		t.Errorf("GetService.Get() created the destination folder during a dry run")
+	}
+}
+
+func TestGetService_fetchSizes(t *testing.T) {
+	downloads := []*chartDownload{{}, {}, {upToDate: true}, {}, {size: 3}}
+	var inFlight, most atomic.Int32
+	g := &GetService{concurrency: 2}
+	g.fetchSizes(downloads, func(_ *chartDownload) int64 {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			if m := most.Load(); n <= m || most.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		return 1
+	})
+
+	var sizes []int64
+	for _, download := range downloads {
+		sizes = append(sizes, download.size)
+	}
+	if want := []int64{1, 1, 0, 1, 3}; !reflect.DeepEqual(sizes, want) {
+		t.Errorf("GetService.fetchSizes() sizes = %v, want %v", sizes, want)
+	}
+	if most.Load() != 2 {
+		t.Errorf("GetService.fetchSizes() sent %d requests at once, want 2", most.Load())
+	}
+}
+
+func Test_formatSize(t *testing.T) {
+	tests := []struct {
+		size int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{5 * 1024 * 1024, "5.0 MiB"},
+		{3 * 1024 * 1024 * 1024, "3.0 GiB"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := formatSize(tt.size); got != tt.want {
+				t.Errorf("formatSize() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// WithPrune deletes, once the charts are mirrored, the chart archives and
+// provenance files of the destination folder that are not part of the
+// selected charts nor of the dependencies mirrored next to them, so the folder
+// tracks the upstream repository exactly. When dryRun is set, the files are
+// only listed.
+func WithPrune(dryRun bool) GetOption {
+	return func(g *GetService) {
+		g.prune = true
+		g.pruneDryRun = dryRun
+	}
+}
+
+// pruneCharts deletes the chart archives and provenance files of the
+// destination folder that neither downloads nor the dependencies mirrored into
+// it reference, when pruning.
+func (g *GetService) pruneCharts(downloads []*chartDownload) error {
+	if !g.prune {
+		return nil
+	}
+
+	stale, err := staleChartFiles(g.config.Name, append(downloads, g.dependencyDownloads(g.config.Name)...))
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, name := range stale {
+		if g.pruneDryRun {
+			g.logger.Printf("Would prune %q", name)
+			continue
+		}
+
+		g.logVerbose("Pruning %q", name)
+		if err := os.Remove(name); err != nil {
+			if g.ignoreErrors {
+				g.logger.Printf("WARNING: pruning %q - %s", name, err)
+				continue
+			}
+			errs = append(errs, fmt.Errorf("cannot prune %q: %w", name, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("cannot prune destination folder: %w", errors.Join(errs...))
+	}
+
+	return nil
+}
+
+// staleChartFiles lists, in name order, the chart archives and provenance
+// files of folder that are not referenced by downloads.
+func staleChartFiles(folder string, downloads []*chartDownload) ([]string, error) {
+	referenced := map[string]bool{}
+	for _, download := range downloads {
+		referenced[path.Base(download.path)] = true
+		referenced[path.Base(download.path)+provenanceExt] = true
+	}
+
+	entries, err := os.ReadDir(folder)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read destination folder: %w", err)
+	}
+
+	var stale []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || referenced[name] {
+			continue
+		}
+
+		if strings.HasSuffix(name, ".tgz") || strings.HasSuffix(name, ".tgz"+provenanceExt) {
+			stale = append(stale, path.Join(folder, name))
+		}
+	}
+
+	return stale, nil
+}
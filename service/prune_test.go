@@ -0,0 +1,82 @@
+package service
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/konstructio/helm-mirror/fixtures"
+	"k8s.io/helm/pkg/repo"
+)
+
+func TestGetService_pruneCharts(t *testing.T) {
+	svr := fixtures.NewTestRepositoryServer(t)
+	tests := []struct {
+		name   string
+		dryRun bool
+		want   []string
+	}{
+		{"1", false, []string{"chart2-1.0.1.tgz", "index.yaml", "notes.txt", "subfolder"}},
+		{"2", true, []string{"chart1-2.11.0.tgz", "chart2-0.0.0-rc1.tgz.prov", "chart2-1.0.1.tgz", "index.yaml", "notes.txt", "old-0.1.0.tgz", "subfolder"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, name := range []string{"chart1-2.11.0.tgz", "chart2-0.0.0-rc1.tgz.prov", "old-0.1.0.tgz", "notes.txt", "subfolder/chart-1.0.0.tgz"} {
+				os.MkdirAll(path.Dir(path.Join(dir, name)), 0o755)
+				os.WriteFile(path.Join(dir, name), []byte(name), 0o600)
+			}
+
+			g := NewGetService(repo.Entry{Name: dir, URL: svr.URL}, false, false, false, fakeLogger, "", "chart2", "", WithPrune(tt.dryRun))
+			if err := g.Get(); err != nil {
+				t.Errorf("GetService.Get() error = %v", err)
+				return
+			}
+
+			files, err := os.ReadDir(dir)
+			if err != nil {
+				t.Errorf("reading destination: %s", err)
+			}
+			got := []string{}
+			for _, f := range files {
+				got = append(got, f.Name())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetService.Get() left %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetService_pruneChartsKeepsDependencies(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"chart-1.0.0.tgz", "dependency-1.0.0.tgz", "other-1.0.0.tgz", "old-0.1.0.tgz"} {
+		os.WriteFile(path.Join(dir, name), []byte(name), 0o600)
+	}
+
+	g := &GetService{
+		config: repo.Entry{Name: dir},
+		logger: fakeLogger,
+		prune:  true,
+		dependencyState: &dependencyState{downloads: []*chartDownload{
+			{path: path.Join(dir, "dependency-1.0.0.tgz")},
+			{path: path.Join(dir, "dependencies", "example.com", "other-1.0.0.tgz")},
+		}},
+	}
+	if err := g.pruneCharts([]*chartDownload{{path: path.Join(dir, "chart-1.0.0.tgz")}}); err != nil {
+		t.Errorf("GetService.pruneCharts() error = %v", err)
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Errorf("reading destination: %s", err)
+	}
+	got := []string{}
+	for _, f := range files {
+		got = append(got, f.Name())
+	}
+	if want := []string{"chart-1.0.0.tgz", "dependency-1.0.0.tgz"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetService.pruneCharts() left %v, want %v", got, want)
+	}
+}
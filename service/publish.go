@@ -0,0 +1,62 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Publisher uploads mirrored charts to a destination repository
+type Publisher interface {
+	// Publish uploads the chart archive at chartPath. It returns false
+	// without error when the destination already holds that chart version.
+	Publish(chartPath string, name string, version string) (bool, error)
+	// String describes the destination in logs
+	String() string
+}
+
+// WithPublisher uploads every mirrored chart to publisher once it has been
+// downloaded, including the charts already up to date in the destination
+// folder: the publisher skips the versions it already holds, so a publish that
+// failed before is retried. It can be given several times to publish to
+// several destinations.
+func WithPublisher(publisher Publisher) GetOption {
+	return func(g *GetService) {
+		g.publishers = append(g.publishers, publisher)
+	}
+}
+
+// publishCharts hands the charts that are present in the destination folder
+// to every publisher. Errors are logged and skipped when errors are being
+// ignored, aggregated and returned otherwise.
+func (g *GetService) publishCharts(downloads []*chartDownload) error {
+	var errs []error
+	for _, publisher := range g.publishers {
+		for _, download := range downloads {
+			if !download.mirrored() {
+				continue
+			}
+
+			published, err := publisher.Publish(download.path, download.name, download.version)
+			if err != nil {
+				if g.ignoreErrors {
+					g.logger.Printf("WARNING: publishing chart %s(%s) to %s - %s", download.name, download.version, publisher, err)
+					continue
+				}
+				errs = append(errs, fmt.Errorf("cannot publish chart %s(%s) to %s: %w", download.name, download.version, publisher, err))
+				continue
+			}
+
+			if published {
+				g.logVerbose("Published chart %q (version %s) to %s", download.name, download.version, publisher)
+			} else {
+				g.logVerbose("Skipping chart %q (version %s): already published to %s", download.name, download.version, publisher)
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("cannot publish charts: %w", errors.Join(errs...))
+	}
+
+	return nil
+}
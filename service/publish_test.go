@@ -0,0 +1,28 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/konstructio/helm-mirror/fixtures"
+	"k8s.io/helm/pkg/repo"
+)
+
+func TestGetService_publishChartsUpToDate(t *testing.T) {
+	svr := fixtures.NewRepositoryServer()
+	defer svr.Close()
+	dir := t.TempDir()
+
+	// the second run finds the chart up to date and still hands it to the
+	// publisher, which knows whether it already holds it
+	for i := range 2 {
+		publisher := &mockPublisher{}
+		g := NewGetService(repo.Entry{Name: dir, URL: svr.URL}, false, false, false, fakeLogger, "", "chart2", "", WithPublisher(publisher))
+		if err := g.Get(); err != nil {
+			t.Errorf("GetService.Get() run %d error = %v", i+1, err)
+		}
+		if want := []string{"chart2-1.0.1"}; !reflect.DeepEqual(publisher.published, want) {
+			t.Errorf("GetService.Get() run %d published = %v, want %v", i+1, publisher.published, want)
+		}
+	}
+}
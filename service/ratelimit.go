@@ -0,0 +1,102 @@
+package service
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxLimitedRead bounds the size of each read of a rate limited body, so
+// bandwidth is spread evenly over time
+const maxLimitedRead = 32 * 1024
+
+// WithRateLimits caps the traffic with the chart repository to maxRate bytes
+// per second and maxRequests requests per second, across all the downloads of
+// the service. Zero means no limit.
+func WithRateLimits(maxRate int64, maxRequests float64) GetOption {
+	return func(g *GetService) {
+		g.bandwidth = newLimiter(float64(maxRate))
+		g.requests = newLimiter(maxRequests)
+	}
+}
+
+// limiter spaces events out so they happen at rate per second on average. A
+// nil limiter never waits.
+type limiter struct {
+	mu   sync.Mutex
+	rate float64
+	next time.Time
+}
+
+func newLimiter(rate float64) *limiter {
+	if rate <= 0 {
+		return nil
+	}
+
+	return &limiter{rate: rate}
+}
+
+// wait blocks until the events reserved before are over, and reserves the
+// time n more events take.
+func (l *limiter) wait(n int) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	start := l.next
+	l.next = l.next.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+	l.mu.Unlock()
+
+	time.Sleep(time.Until(start))
+}
+
+// limitTransport wraps base so requests and response bodies go through the
+// rate limiters of the service.
+//
+//nolint:ireturn
+func (g *GetService) limitTransport(base http.RoundTripper) http.RoundTripper {
+	if g.requests == nil && g.bandwidth == nil {
+		return base
+	}
+
+	return &limitedTransport{base: base, requests: g.requests, bandwidth: g.bandwidth}
+}
+
+type limitedTransport struct {
+	base      http.RoundTripper
+	requests  *limiter
+	bandwidth *limiter
+}
+
+func (t *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.requests.wait(1)
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err //nolint:wrapcheck // the client wraps transport errors
+	}
+
+	if t.bandwidth != nil {
+		resp.Body = &limitedBody{ReadCloser: resp.Body, bandwidth: t.bandwidth}
+	}
+
+	return resp, nil
+}
+
+type limitedBody struct {
+	io.ReadCloser
+	bandwidth *limiter
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	size := min(len(p), maxLimitedRead, max(int(b.bandwidth.rate), 1))
+	n, err := b.ReadCloser.Read(p[:size])
+	b.bandwidth.wait(n)
+	return n, err //nolint:wrapcheck // io.EOF must be returned as is
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"k8s.io/helm/pkg/repo"
+)
+
+func Test_limiter_wait(t *testing.T) {
+	tests := []struct {
+		name   string
+		rate   float64
+		events []int
+		min    time.Duration
+	}{
+		{"1", 0, []int{1, 1, 1}, 0},
+		{"2", 20, []int{1, 1, 1}, 100 * time.Millisecond},
+		{"3", 1000, []int{100, 100, 100}, 200 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newLimiter(tt.rate)
+			start := time.Now()
+			for _, n := range tt.events {
+				l.wait(n)
+			}
+			if elapsed := time.Since(start); elapsed < tt.min {
+				t.Errorf("limiter.wait() took %s, want at least %s", elapsed, tt.min)
+			}
+		})
+	}
+}
+
+func TestGetService_limitTransport(t *testing.T) {
+	content := bytes.Repeat([]byte("a"), 2000)
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}))
+	defer svr.Close()
+
+	tests := []struct {
+		name        string
+		maxRate     int64
+		maxRequests float64
+		requests    int
+		min         time.Duration
+	}{
+		{"1", 0, 0, 3, 0},
+		{"2", 0, 10, 3, 200 * time.Millisecond},
+		{"3", 10000, 0, 2, 200 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &GetService{config: repo.Entry{URL: svr.URL}, logger: fakeLogger}
+			WithRateLimits(tt.maxRate, tt.maxRequests)(g)
+			client, err := g.newHTTPGetter(svr.URL)
+			if err != nil {
+				t.Errorf("newHTTPGetter() error = %v", err)
+				return
+			}
+
+			start := time.Now()
+			for i := 0; i < tt.requests; i++ {
+				got, err := client.Get(svr.URL + "/chart.tgz")
+				if err != nil {
+					t.Errorf("httpGetter.Get() error = %v", err)
+					return
+				}
+				if n, _ := io.Copy(io.Discard, got); n != int64(len(content)) {
+					t.Errorf("httpGetter.Get() read %d bytes, want %d", n, len(content))
+				}
+			}
+			if elapsed := time.Since(start); elapsed < tt.min {
+				t.Errorf("%d downloads took %s, want at least %s", tt.requests, elapsed, tt.min)
+			}
+		})
+	}
+}
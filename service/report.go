@@ -0,0 +1,208 @@
+package service
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ReportFormat selects the format of the run report
+type ReportFormat string
+
+const (
+	// ReportJSON writes the report as a JSON document
+	ReportJSON ReportFormat = "json"
+	// ReportJUnit writes the report as a JUnit XML document, with a test
+	// case per chart version
+	ReportJUnit ReportFormat = "junit"
+)
+
+// ReportStatus is the outcome of mirroring a chart version
+type ReportStatus string
+
+const (
+	// ReportDownloaded charts were downloaded
+	ReportDownloaded ReportStatus = "downloaded"
+	// ReportSkipped charts were not downloaded, either because the local
+	// copy is up to date or because the run stopped on an earlier failure
+	ReportSkipped ReportStatus = "skipped"
+	// ReportFailed charts could not be downloaded
+	ReportFailed ReportStatus = "failed"
+)
+
+// Report describes the outcome of a mirror run
+type Report struct {
+	Repository  string        `json:"repository"`
+	Destination string        `json:"destination"`
+	Started     time.Time     `json:"started"`
+	Duration    float64       `json:"durationSeconds"`
+	Charts      []ReportChart `json:"charts"`
+	Downloaded  int           `json:"downloaded"`
+	Skipped     int           `json:"skipped"`
+	Failed      int           `json:"failed"`
+	Error       string        `json:"error,omitempty"`
+}
+
+// ReportChart describes the outcome of mirroring a chart version
+type ReportChart struct {
+	Name     string       `json:"name"`
+	Version  string       `json:"version"`
+	Status   ReportStatus `json:"status"`
+	Bytes    int64        `json:"bytes"`
+	Duration float64      `json:"durationSeconds"`
+	Reason   string       `json:"reason,omitempty"`
+	Error    string       `json:"error,omitempty"`
+}
+
+// WithReport writes a report listing every chart version attempted, with its
+// outcome, to the file at reportPath once the run ends, even when it fails.
+func WithReport(reportPath string, format ReportFormat) GetOption {
+	return func(g *GetService) {
+		g.reportPath = reportPath
+		g.reportFormat = format
+	}
+}
+
+// newReport describes the attempted downloads of a run that started at
+// started and ended with runErr.
+func (g *GetService) newReport(started time.Time, runErr error) *Report {
+	report := &Report{
+		Repository:  g.config.URL,
+		Destination: g.config.Name,
+		Started:     started,
+		Duration:    time.Since(started).Seconds(),
+		Charts:      []ReportChart{},
+	}
+	if runErr != nil {
+		report.Error = runErr.Error()
+	}
+
+	for _, download := range g.attempted {
+		chart := ReportChart{
+			Name:     download.name,
+			Version:  download.version,
+			Bytes:    download.size,
+			Duration: download.duration.Seconds(),
+		}
+
+		switch {
+		case download.upToDate:
+			chart.Status = ReportSkipped
+			chart.Reason = "up to date"
+			chart.Bytes = localSize(download.path)
+			report.Skipped++
+		case !download.started:
+			chart.Status = ReportSkipped
+			chart.Reason = "not attempted after an earlier failure"
+			chart.Bytes = 0
+			report.Skipped++
+		case download.err != nil:
+			chart.Status = ReportFailed
+			chart.Error = download.err.Error()
+			report.Failed++
+		default:
+			chart.Status = ReportDownloaded
+			report.Downloaded++
+		}
+
+		report.Charts = append(report.Charts, chart)
+	}
+
+	return report
+}
+
+// writeReport writes the report of the run to the report file.
+func (g *GetService) writeReport(started time.Time, runErr error) error {
+	report := g.newReport(started, runErr)
+
+	var (
+		content []byte
+		err     error
+	)
+	if g.reportFormat == ReportJUnit {
+		content, err = xml.MarshalIndent(report.junit(), "", "  ")
+		content = append([]byte(xml.Header), content...)
+	} else {
+		content, err = json.MarshalIndent(report, "", "  ")
+	}
+	if err != nil {
+		return fmt.Errorf("cannot encode report: %w", err)
+	}
+
+	if err := os.WriteFile(g.reportPath, append(content, '\n'), 0o600); err != nil {
+		return fmt.Errorf("cannot write report: %w", err)
+	}
+
+	return nil
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Cases     []junitTestCase `xml:"testcase"`
+	SystemErr string          `xml:"system-err,omitempty"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+}
+
+// junit converts the report to a JUnit document with a test suite for the
+// repository and a test case per chart version. A run failing outside of the
+// charts is reported as an error of the suite.
+func (r *Report) junit() *junitTestSuites {
+	suite := junitTestSuite{
+		Name:      r.Repository,
+		Tests:     len(r.Charts),
+		Failures:  r.Failed,
+		Skipped:   r.Skipped,
+		Time:      fmt.Sprintf("%.3f", r.Duration),
+		Timestamp: r.Started.UTC().Format(time.RFC3339),
+		Cases:     []junitTestCase{},
+	}
+
+	for _, chart := range r.Charts {
+		testCase := junitTestCase{
+			ClassName: chart.Name,
+			Name:      chart.Name + "-" + chart.Version,
+			Time:      fmt.Sprintf("%.3f", chart.Duration),
+		}
+
+		switch chart.Status {
+		case ReportFailed:
+			testCase.Failure = &junitMessage{Message: chart.Error}
+		case ReportSkipped:
+			testCase.Skipped = &junitMessage{Message: chart.Reason}
+		case ReportDownloaded:
+		}
+
+		suite.Cases = append(suite.Cases, testCase)
+	}
+
+	if r.Error != "" && r.Failed == 0 {
+		suite.Errors = 1
+		suite.SystemErr = r.Error
+	}
+
+	return &junitTestSuites{Suites: []junitTestSuite{suite}}
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/konstructio/helm-mirror/fixtures"
+	"k8s.io/helm/pkg/repo"
+)
+
+func TestGetService_GetReport(t *testing.T) {
+	svr := fixtures.NewRepositoryServer()
+	defer svr.Close()
+	dir, err := os.MkdirTemp("", "helmmirrortests")
+	if err != nil {
+		t.Errorf("Creating tmp directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// chart1 is up to date, the chart4 entry points to a missing archive
+	os.WriteFile(path.Join(dir, "chart1-2.11.0.tgz"), fixtures.ChartTGZ(), 0o600)
+	reportPath := path.Join(dir, "report.json")
+
+	g := NewGetService(repo.Entry{Name: dir, URL: svr.URL}, true, false, true, fakeLogger, "", "", "", WithReport(reportPath, ReportJSON))
+	if err := g.Get(); err != nil {
+		t.Errorf("GetService.Get() error = %v", err)
+	}
+
+	content, err := os.ReadFile(reportPath)
+	if err != nil {
+		t.Errorf("reading report: %s", err)
+		return
+	}
+	var report Report
+	if err := json.Unmarshal(content, &report); err != nil {
+		t.Errorf("decoding report: %s", err)
+	}
+	if report.Repository != svr.URL || report.Downloaded != 3 || report.Skipped != 1 || report.Failed != 1 || len(report.Charts) != 5 {
+		t.Errorf("GetService.Get() report = %s", content)
+	}
+	for _, chart := range report.Charts {
+		switch chart.Status {
+		case ReportDownloaded, ReportSkipped:
+			if chart.Bytes != int64(len(fixtures.ChartTGZ())) || chart.Error != "" {
+				t.Errorf("GetService.Get() report chart = %+v", chart)
+			}
+		case ReportFailed:
+			if chart.Error == "" {
+				t.Errorf("GetService.Get() report chart = %+v, want an error", chart)
+			}
+		}
+	}
+}
+
+func TestGetService_GetReportJUnit(t *testing.T) {
+	dir, err := os.MkdirTemp("", "helmmirrortests")
+	if err != nil {
+		t.Errorf("Creating tmp directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	reportPath := path.Join(dir, "report.xml")
+
+	g := NewGetService(repo.Entry{Name: dir, URL: "http://127.0.0.1:0"}, false, false, false, fakeLogger, "", "", "", WithReport(reportPath, ReportJUnit))
+	if err := g.Get(); err == nil {
+		t.Errorf("GetService.Get() error = nil, want the unreachable repository to fail")
+	}
+
+	content, err := os.ReadFile(reportPath)
+	if err != nil {
+		t.Errorf("reading report: %s", err)
+		return
+	}
+	var suites junitTestSuites
+	if err := xml.Unmarshal(content, &suites); err != nil {
+		t.Errorf("decoding report: %s", err)
+	}
+	if len(suites.Suites) != 1 || suites.Suites[0].Errors != 1 || suites.Suites[0].SystemErr == "" || len(suites.Suites[0].Cases) != 0 {
+		t.Errorf("GetService.Get() report = %s", content)
+	}
+}
+
+func TestReport_junit(t *testing.T) {
+	report := &Report{
+		Repository: "https://charts.example.com",
+		Charts: []ReportChart{
+			{Name: "nginx", Version: "1.0.0", Status: ReportDownloaded},
+			{Name: "nginx", Version: "0.9.0", Status: ReportSkipped, Reason: "up to date"},
+			{Name: "redis", Version: "1.0.0", Status: ReportFailed, Error: "digest mismatch"},
+		},
+		Downloaded: 1,
+		Skipped:    1,
+		Failed:     1,
+		Error:      "cannot mirror charts: digest mismatch",
+	}
+
+	suite := report.junit().Suites[0]
+	if suite.Tests != 3 || suite.Failures != 1 || suite.Skipped != 1 || suite.Errors != 0 {
+		t.Errorf("Report.junit() = %+v", suite)
+	}
+	if suite.Cases[0].Failure != nil || suite.Cases[0].Skipped != nil {
+		t.Errorf("Report.junit() downloaded case = %+v", suite.Cases[0])
+	}
+	if suite.Cases[1].Skipped == nil || suite.Cases[1].Skipped.Message != "up to date" {
+		t.Errorf("Report.junit() skipped case = %+v", suite.Cases[1])
+	}
+	if suite.Cases[2].Failure == nil || suite.Cases[2].Failure.Message != "digest mismatch" || suite.Cases[2].Name != "redis-1.0.0" {
+		t.Errorf("Report.junit() failed case = %+v", suite.Cases[2])
+	}
+}